@@ -0,0 +1,426 @@
+package redis
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+const (
+	DefaultAddr            = "127.0.0.1:6379"
+	DefaultMaxIdle         = 2
+	DefaultMaxActive       = 20
+	DefaultIdleTimeout     = 180 * time.Second
+	DefaultDialTimeout     = 3 * time.Second
+	DefaultReadTimeout     = 3 * time.Second
+	DefaultWriteTimeout    = 3 * time.Second
+	DefaultHealthCheckIdle = time.Minute
+	DefaultExpire          = 300 // seconds
+	defaultDialKeepAlive   = 5 * time.Minute
+)
+
+// Client is a Redis client with its own connection pool. Create one Client
+// per Redis server with NewClient; the package level functions use the
+// client set up by ConnectInit.
+//
+// A Client is safe for concurrent use by multiple goroutines.
+type Client struct {
+	pool *redis.Pool
+
+	addr         string        // host:port of the server
+	password     string        // AUTH password, empty for none
+	db           int           // database selected on every connection
+	maxIdle      int           // idle connections kept in the pool
+	maxActive    int           // connections allocated at most, 0 means no limit
+	wait         bool          // wait for a free connection at maxActive
+	idleTimeout  time.Duration // idle connections are closed after it
+	dialTimeout  time.Duration
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+	testOnBorrow func(c redis.Conn, t time.Time) error
+	codec        Codec // encoding of SetObject and GetObject
+	txMaxRetries int   // retries of Transaction after a conflict
+
+	loader     *Loader // used by GetOrLoad
+	loaderOnce sync.Once
+}
+
+// ClientOptionFunc is a function that configures a Client.
+// It is used in NewClient.
+type ClientOptionFunc func(*Client) error
+
+// NewClient creates a new Client. Without options it connects to
+// 127.0.0.1:6379, database 0, and pings connections idle for more than a
+// minute before handing them out. No connection is made before the first
+// command, use Ping to check the settings.
+func NewClient(options ...ClientOptionFunc) (*Client, error) {
+	c := &Client{
+		addr:         DefaultAddr,
+		maxIdle:      DefaultMaxIdle,
+		maxActive:    DefaultMaxActive,
+		idleTimeout:  DefaultIdleTimeout,
+		dialTimeout:  DefaultDialTimeout,
+		readTimeout:  DefaultReadTimeout,
+		writeTimeout: DefaultWriteTimeout,
+		testOnBorrow: PingIdle(DefaultHealthCheckIdle),
+		codec:        JSONCodec,
+		txMaxRetries: DefaultTxMaxRetries,
+	}
+	for _, option := range options {
+		if err := option(c); err != nil {
+			return nil, err
+		}
+	}
+	c.pool = &redis.Pool{
+		MaxIdle:      c.maxIdle,
+		MaxActive:    c.maxActive,
+		Wait:         c.wait,
+		IdleTimeout:  c.idleTimeout,
+		TestOnBorrow: c.testOnBorrow,
+		Dial:         c.dial,
+	}
+	return c, nil
+}
+
+// dial opens a connection, authenticates and selects the database.
+func (c *Client) dial() (redis.Conn, error) {
+	conn, err := redis.Dial("tcp", c.addr,
+		redis.DialConnectTimeout(c.dialTimeout),
+		redis.DialReadTimeout(c.readTimeout),
+		redis.DialWriteTimeout(c.writeTimeout),
+		redis.DialKeepAlive(defaultDialKeepAlive))
+	if err != nil {
+		return nil, err
+	}
+	if c.password != "" {
+		if _, err := conn.Do("AUTH", c.password); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if c.db != 0 {
+		if _, err := conn.Do("SELECT", c.db); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// SetAddr sets the host:port of the server.
+func SetAddr(addr string) ClientOptionFunc {
+	return func(c *Client) error {
+		if addr == "" {
+			return errors.New("redis: empty address")
+		}
+		c.addr = addr
+		return nil
+	}
+}
+
+// SetPassword sets the password sent with AUTH on every new connection.
+func SetPassword(password string) ClientOptionFunc {
+	return func(c *Client) error {
+		c.password = strings.TrimSpace(password)
+		return nil
+	}
+}
+
+// SetDB sets the database selected on every new connection.
+func SetDB(db int) ClientOptionFunc {
+	return func(c *Client) error {
+		if db < 0 {
+			return errors.New("redis: invalid db " + strconv.Itoa(db))
+		}
+		c.db = db
+		return nil
+	}
+}
+
+// SetPoolSize sets the number of idle connections kept in the pool and
+// the number of connections allocated at most, 0 meaning no limit. If
+// wait is true, commands wait for a free connection at the limit instead
+// of failing with redis.ErrPoolExhausted.
+func SetPoolSize(maxIdle, maxActive int, wait bool) ClientOptionFunc {
+	return func(c *Client) error {
+		c.maxIdle = maxIdle
+		c.maxActive = maxActive
+		c.wait = wait
+		return nil
+	}
+}
+
+// SetIdleTimeout closes connections that stayed idle in the pool longer
+// than timeout.
+func SetIdleTimeout(timeout time.Duration) ClientOptionFunc {
+	return func(c *Client) error {
+		c.idleTimeout = timeout
+		return nil
+	}
+}
+
+// SetDialTimeout sets the timeout for connecting to the server.
+func SetDialTimeout(timeout time.Duration) ClientOptionFunc {
+	return func(c *Client) error {
+		c.dialTimeout = timeout
+		return nil
+	}
+}
+
+// SetReadTimeout sets the timeout for reading a reply. Blocking commands
+// like BRPOP need a longer one than their own timeout.
+func SetReadTimeout(timeout time.Duration) ClientOptionFunc {
+	return func(c *Client) error {
+		c.readTimeout = timeout
+		return nil
+	}
+}
+
+// SetWriteTimeout sets the timeout for writing a command.
+func SetWriteTimeout(timeout time.Duration) ClientOptionFunc {
+	return func(c *Client) error {
+		c.writeTimeout = timeout
+		return nil
+	}
+}
+
+// SetTestOnBorrow sets the health check of idle connections taken from the
+// pool; a connection failing it is closed and another one is taken. nil
+// disables the check.
+func SetTestOnBorrow(test func(c redis.Conn, t time.Time) error) ClientOptionFunc {
+	return func(c *Client) error {
+		c.testOnBorrow = test
+		return nil
+	}
+}
+
+// PingIdle returns a TestOnBorrow check pinging connections that were idle
+// for longer than idle.
+func PingIdle(idle time.Duration) func(c redis.Conn, t time.Time) error {
+	return func(c redis.Conn, t time.Time) error {
+		if time.Since(t) < idle {
+			return nil
+		}
+		_, err := c.Do("PING")
+		return err
+	}
+}
+
+// Pool returns the connection pool of the Client.
+func (c *Client) Pool() *redis.Pool {
+	return c.pool
+}
+
+// Conn returns a connection from the pool, which the caller must close.
+func (c *Client) Conn() redis.Conn {
+	return c.pool.Get()
+}
+
+// Close closes the connection pool.
+func (c *Client) Close() error {
+	return c.pool.Close()
+}
+
+// Ping checks that the server can be reached with the settings of the
+// Client.
+func (c *Client) Ping() error {
+	conn := c.pool.Get()
+	defer conn.Close()
+	_, err := conn.Do("PING")
+	return err
+}
+
+// do runs a single command on a pooled connection.
+func (c *Client) do(cmd string, args ...interface{}) (interface{}, error) {
+	conn := c.pool.Get()
+	defer conn.Close()
+	return conn.Do(cmd, args...)
+}
+
+// doExpire runs cmd on key and, if expire is given, atomically sets the
+// expiration of key like SetValueAndExpire: expire[0] seconds, or 300s if
+// it is 0.
+func (c *Client) doExpire(expire []int, cmd, key string,
+	args ...interface{}) (interface{}, error) {
+	if len(expire) == 0 {
+		return c.do(cmd, append([]interface{}{key}, args...)...)
+	}
+	conn := c.pool.Get()
+	defer conn.Close()
+	return execWithExpire(conn, expireOrDefault(expire[0]), cmd, key, args...)
+}
+
+var (
+	defaultClient   *Client
+	defaultClientMu sync.RWMutex
+)
+
+// DefaultClient returns the Client used by the package level functions,
+// as set up by ConnectInit or SetDefaultClient. Without them it talks to
+// 127.0.0.1:6379.
+func DefaultClient() *Client {
+	defaultClientMu.RLock()
+	c := defaultClient
+	defaultClientMu.RUnlock()
+	if c != nil {
+		return c
+	}
+	defaultClientMu.Lock()
+	defer defaultClientMu.Unlock()
+	if defaultClient == nil {
+		defaultClient, _ = NewClient()
+	}
+	return defaultClient
+}
+
+// SetDefaultClient replaces the Client used by the package level
+// functions. The previous one is not closed.
+func SetDefaultClient(c *Client) {
+	swapDefaultClient(c)
+}
+
+// swapDefaultClient replaces the default Client and returns the previous
+// one, nil if none was set up yet.
+func swapDefaultClient(c *Client) *Client {
+	defaultClientMu.Lock()
+	defer defaultClientMu.Unlock()
+	old := defaultClient
+	defaultClient = c
+	return old
+}
+
+func (c *Client) GetValue(key string) (interface{}, error) {
+	return c.do("GET", key)
+}
+
+func (c *Client) GetStringValue(key string) (string, error) {
+	reply, err := c.do("GET", key)
+	if err != nil {
+		return "", err
+	}
+	return redis.String(reply, err)
+}
+
+// SetValue sets key to value, expiring after 300s.
+func (c *Client) SetValue(key string, value interface{}) error {
+	return c.SetValueAndExpire(key, value, DefaultExpire)
+}
+
+// SetValueAndExpire sets key to value, expiring after expire seconds or
+// 300s if expire is 0. The value and its expiration are set atomically.
+func (c *Client) SetValueAndExpire(key string, value interface{},
+	expire int) error {
+	_, err := c.do("SET", key, value, "EX", expireOrDefault(expire))
+	return err
+}
+
+func (c *Client) SetValueNoExpire(key string, value interface{}) error {
+	_, err := c.do("SET", key, value)
+	return err
+}
+
+// SetNxKeyAndExpire sets key only if it does not exist yet, expiring after
+// expire in the unit def: "px" for milliseconds or "ex" for seconds. The
+// reply is "OK" on success and nil otherwise.
+func (c *Client) SetNxKeyAndExpire(key string, value interface{}, def string,
+	expire int) (interface{}, error) {
+	return c.do("SET", key, value, def, expire, "nx")
+}
+
+// SetExpire sets the expiration of key in seconds, by default 300s.
+func (c *Client) SetExpire(key string, expire ...int) error {
+	tExpire := DefaultExpire
+	if len(expire) > 0 {
+		tExpire = expire[0]
+	}
+	_, err := c.do("EXPIRE", key, tExpire)
+	return err
+}
+
+func (c *Client) Delete(key string) error {
+	_, err := c.do("DEL", key)
+	return err
+}
+
+// SetSetValue adds value to the set key and lets the set expire after
+// 300s.
+func (c *Client) SetSetValue(key string, value interface{}) error {
+	return c.SetSetValueBasedOnExpire(key, value, DefaultExpire)
+}
+
+// IsSetMember reports whether value is a member of the set key.
+func (c *Client) IsSetMember(key string, value interface{}) bool {
+	exist, err := redis.Bool(c.do("SISMEMBER", key, value))
+	if err != nil {
+		return false
+	}
+	return exist
+}
+
+// GetSetAllValue returns all members of the set key.
+func (c *Client) GetSetAllValue(key string) (interface{}, error) {
+	return c.do("SMEMBERS", key)
+}
+
+func (c *Client) ExistKey(key string) bool {
+	exist, err := redis.Bool(c.do("EXISTS", key))
+	if err != nil {
+		return false
+	}
+	return exist
+}
+
+// GetLenOfSet returns the number of members of the set key.
+func (c *Client) GetLenOfSet(key string) (int, error) {
+	return redis.Int(c.do("SCARD", key))
+}
+
+// GetLenOfList returns the length of the list key.
+func (c *Client) GetLenOfList(key string) (int, error) {
+	return redis.Int(c.do("LLEN", key))
+}
+
+// PushElementWithTail appends value to the list key, which expires after
+// expire seconds or 300s if expire is 0, atomically.
+func (c *Client) PushElementWithTail(key string, value interface{},
+	expire int) error {
+	_, err := c.doExpire([]int{expire}, "RPUSH", key, value)
+	return err
+}
+
+// PopElementFromHead removes and returns the first element of the list
+// key.
+func (c *Client) PopElementFromHead(key string) (interface{}, error) {
+	return c.do("LPOP", key)
+}
+
+func (c *Client) GetAllElementsFromList(key string) (interface{}, error) {
+	return c.do("LRANGE", key, 0, -1)
+}
+
+// SetValueBasedOnExpire is the same as SetValueAndExpire.
+func (c *Client) SetValueBasedOnExpire(key string, value interface{},
+	expire int) error {
+	return c.SetValueAndExpire(key, value, expire)
+}
+
+// SetSetValueBasedOnExpire adds value to the set key, which expires after
+// expire seconds, atomically.
+func (c *Client) SetSetValueBasedOnExpire(key string, value interface{},
+	expire int) error {
+	conn := c.pool.Get()
+	defer conn.Close()
+	_, err := execWithExpire(conn, expire, "SADD", key, value)
+	return err
+}
+
+func expireOrDefault(expire int) int {
+	if expire == 0 {
+		return DefaultExpire
+	}
+	return expire
+}
@@ -0,0 +1,139 @@
+package redis
+
+import (
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/garyburd/redigo/redis"
+	gc "gopkg.in/check.v1"
+)
+
+type clientSuite struct {
+	mr  *miniredis.Miniredis
+	cli *Client
+}
+
+var _ = gc.Suite(&clientSuite{})
+
+func (s *clientSuite) SetUpTest(c *gc.C) {
+	mr, err := miniredis.Run()
+	c.Assert(err, gc.IsNil)
+	s.mr = mr
+	s.cli, err = NewClient(SetAddr(mr.Addr()))
+	c.Assert(err, gc.IsNil)
+}
+
+func (s *clientSuite) TearDownTest(c *gc.C) {
+	s.cli.Close()
+	s.mr.Close()
+}
+
+func (s *clientSuite) TestValues(c *gc.C) {
+	c.Assert(s.cli.SetValue("k", "abc"), gc.IsNil)
+	v, err := s.cli.GetStringValue("k")
+	c.Assert(err, gc.IsNil)
+	c.Assert(v, gc.Equals, "abc")
+	c.Assert(s.mr.TTL("k"), gc.Equals, 300*time.Second)
+
+	c.Assert(s.cli.SetValueAndExpire("k", "x", 10), gc.IsNil)
+	c.Assert(s.mr.TTL("k"), gc.Equals, 10*time.Second)
+	c.Assert(s.cli.SetValueNoExpire("k", "y"), gc.IsNil)
+	c.Assert(s.mr.TTL("k"), gc.Equals, time.Duration(0))
+	c.Assert(s.cli.SetExpire("k"), gc.IsNil)
+	c.Assert(s.mr.TTL("k"), gc.Equals, 300*time.Second)
+
+	reply, err := s.cli.SetNxKeyAndExpire("k", "z", "ex", 5)
+	c.Assert(err, gc.IsNil)
+	c.Assert(reply, gc.IsNil)
+
+	c.Assert(s.cli.ExistKey("k"), gc.Equals, true)
+	c.Assert(s.cli.Delete("k"), gc.IsNil)
+	c.Assert(s.cli.ExistKey("k"), gc.Equals, false)
+	_, err = s.cli.GetStringValue("k")
+	c.Assert(err, gc.Equals, redis.ErrNil)
+}
+
+func (s *clientSuite) TestSetsAndLists(c *gc.C) {
+	c.Assert(s.cli.SetSetValue("s", "a"), gc.IsNil)
+	c.Assert(s.cli.SetSetValueBasedOnExpire("s", "b", 60), gc.IsNil)
+	c.Assert(s.cli.IsSetMember("s", "a"), gc.Equals, true)
+	c.Assert(s.cli.IsSetMember("s", "c"), gc.Equals, false)
+	n, err := s.cli.GetLenOfSet("s")
+	c.Assert(err, gc.IsNil)
+	c.Assert(n, gc.Equals, 2)
+	c.Assert(s.mr.TTL("s"), gc.Equals, 60*time.Second)
+
+	c.Assert(s.cli.PushElementWithTail("l", "1", 0), gc.IsNil)
+	c.Assert(s.cli.PushElementWithTail("l", "2", 30), gc.IsNil)
+	c.Assert(s.mr.TTL("l"), gc.Equals, 30*time.Second)
+	n, err = s.cli.GetLenOfList("l")
+	c.Assert(err, gc.IsNil)
+	c.Assert(n, gc.Equals, 2)
+	v, err := redis.String(s.cli.PopElementFromHead("l"))
+	c.Assert(err, gc.IsNil)
+	c.Assert(v, gc.Equals, "1")
+	all, err := redis.Strings(s.cli.GetAllElementsFromList("l"))
+	c.Assert(err, gc.IsNil)
+	c.Assert(all, gc.DeepEquals, []string{"2"})
+}
+
+func (s *clientSuite) TestTwoServers(c *gc.C) {
+	mr2, err := miniredis.Run()
+	c.Assert(err, gc.IsNil)
+	defer mr2.Close()
+	cli2, err := NewClient(SetAddr(mr2.Addr()), SetDB(3))
+	c.Assert(err, gc.IsNil)
+	defer cli2.Close()
+
+	c.Assert(s.cli.SetValueNoExpire("k", "cache"), gc.IsNil)
+	c.Assert(cli2.SetValueNoExpire("k", "queue"), gc.IsNil)
+	v, _ := s.mr.Get("k")
+	c.Assert(v, gc.Equals, "cache")
+	mr2.Select(3)
+	v, _ = mr2.Get("k")
+	c.Assert(v, gc.Equals, "queue")
+}
+
+func (s *clientSuite) TestPassword(c *gc.C) {
+	s.mr.RequireAuth("secret")
+	cli, _ := NewClient(SetAddr(s.mr.Addr()), SetPassword("wrong"))
+	defer cli.Close()
+	c.Assert(cli.Ping(), gc.NotNil)
+	cli, _ = NewClient(SetAddr(s.mr.Addr()), SetPassword(" secret "))
+	defer cli.Close()
+	c.Assert(cli.Ping(), gc.IsNil)
+}
+
+func (s *clientSuite) TestTestOnBorrow(c *gc.C) {
+	tested := 0
+	cli, _ := NewClient(SetAddr(s.mr.Addr()),
+		SetTestOnBorrow(func(conn redis.Conn, t time.Time) error {
+			tested++
+			return nil
+		}))
+	defer cli.Close()
+	c.Assert(cli.Ping(), gc.IsNil)
+	c.Assert(cli.Ping(), gc.IsNil)
+	c.Assert(tested, gc.Equals, 1)
+}
+
+func (s *clientSuite) TestConnectInit(c *gc.C) {
+	// the replaced default client is closed, start from a fresh one after
+	defer SetDefaultClient(nil)
+
+	c.Assert(ConnectInit(s.mr.Addr(), "", "1"), gc.IsNil)
+	first := DefaultClient()
+	c.Assert(ConnectInit(s.mr.Addr(), "", "2"), gc.IsNil)
+	c.Assert(first.Ping(), gc.NotNil)
+	c.Assert(SetValueNoExpire("k", "v"), gc.IsNil)
+	s.mr.Select(2)
+	v, _ := s.mr.Get("k")
+	c.Assert(v, gc.Equals, "v")
+
+	cur := DefaultClient()
+	c.Assert(ConnectInit(s.mr.Addr(), "", "x"), gc.ErrorMatches,
+		`redis: invalid db "x".*`)
+	s.mr.RequireAuth("secret")
+	c.Assert(ConnectInit(s.mr.Addr(), "wrong", "0"), gc.NotNil)
+	c.Assert(DefaultClient(), gc.Equals, cur)
+}
@@ -0,0 +1,186 @@
+package redis
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// ErrNil is returned by the typed getters when the key does not exist.
+// It is the redigo error, so conversions with redis.String and friends
+// match it as well.
+var ErrNil = redis.ErrNil
+
+// Codec encodes the objects stored with SetObject.
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+var (
+	// JSONCodec encodes objects as JSON, the default.
+	JSONCodec Codec = jsonCodec{}
+	// GobCodec encodes objects with encoding/gob. A MessagePack codec is
+	// in package msgpackcodec.
+	GobCodec Codec = gobCodec{}
+)
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) { return json.Marshal(v) }
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+type gobCodec struct{}
+
+func (gobCodec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gobCodec) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// SetCodec sets the Codec of SetObject and GetObject, by default
+// JSONCodec.
+func SetCodec(codec Codec) ClientOptionFunc {
+	return func(c *Client) error {
+		c.codec = codec
+		return nil
+	}
+}
+
+// SetObject encodes v with the codec of the Client and stores it in key,
+// expiring after ttl. Zero ttl means no expiration.
+func (c *Client) SetObject(key string, v interface{}, ttl time.Duration) error {
+	data, err := c.codec.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("redis: encode %s: %v", key, err)
+	}
+	if ttl > 0 {
+		_, err = c.do("SET", key, data, "PX", ttlMillis(ttl))
+	} else {
+		_, err = c.do("SET", key, data)
+	}
+	return err
+}
+
+// GetObject decodes the value of key stored with SetObject into v. It
+// returns ErrNil if key does not exist.
+func (c *Client) GetObject(key string, v interface{}) error {
+	data, err := c.GetBytes(key)
+	if err != nil {
+		return err
+	}
+	if err := c.codec.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("redis: decode %s: %v", key, err)
+	}
+	return nil
+}
+
+// GetBytes returns the value of key, or ErrNil if key does not exist.
+func (c *Client) GetBytes(key string) ([]byte, error) {
+	return redis.Bytes(c.do("GET", key))
+}
+
+// GetInt returns the value of key as an int, or ErrNil if key does not
+// exist.
+func (c *Client) GetInt(key string) (int, error) {
+	return redis.Int(c.do("GET", key))
+}
+
+// GetInt64 returns the value of key as an int64, or ErrNil if key does
+// not exist.
+func (c *Client) GetInt64(key string) (int64, error) {
+	return redis.Int64(c.do("GET", key))
+}
+
+// GetFloat64 returns the value of key as a float64, or ErrNil if key does
+// not exist.
+func (c *Client) GetFloat64(key string) (float64, error) {
+	return redis.Float64(c.do("GET", key))
+}
+
+// GetStrings returns the elements of the list or the members of the set
+// key, or ErrNil if key does not exist.
+func (c *Client) GetStrings(key string) ([]string, error) {
+	conn := c.pool.Get()
+	defer conn.Close()
+	typ, err := redis.String(conn.Do("TYPE", key))
+	if err != nil {
+		return nil, err
+	}
+	switch typ {
+	case "none":
+		return nil, ErrNil
+	case "list":
+		return redis.Strings(conn.Do("LRANGE", key, 0, -1))
+	case "set":
+		return redis.Strings(conn.Do("SMEMBERS", key))
+	}
+	return nil, fmt.Errorf("redis: GetStrings of %s key %s", typ, key)
+}
+
+// GetStringMap returns the fields and values of the hash key, or ErrNil if
+// key does not exist.
+func (c *Client) GetStringMap(key string) (map[string]string, error) {
+	m, err := redis.StringMap(c.do("HGETALL", key))
+	if err != nil {
+		return nil, err
+	}
+	if len(m) == 0 {
+		return nil, ErrNil
+	}
+	return m, nil
+}
+
+// ttlMillis converts ttl to milliseconds, at least 1.
+func ttlMillis(ttl time.Duration) int64 {
+	ms := int64(ttl / time.Millisecond)
+	if ms < 1 {
+		ms = 1
+	}
+	return ms
+}
+
+func SetObject(key string, v interface{}, ttl time.Duration) error {
+	return DefaultClient().SetObject(key, v, ttl)
+}
+
+func GetObject(key string, v interface{}) error {
+	return DefaultClient().GetObject(key, v)
+}
+
+func GetBytes(key string) ([]byte, error) {
+	return DefaultClient().GetBytes(key)
+}
+
+func GetInt(key string) (int, error) {
+	return DefaultClient().GetInt(key)
+}
+
+func GetInt64(key string) (int64, error) {
+	return DefaultClient().GetInt64(key)
+}
+
+func GetFloat64(key string) (float64, error) {
+	return DefaultClient().GetFloat64(key)
+}
+
+func GetStrings(key string) ([]string, error) {
+	return DefaultClient().GetStrings(key)
+}
+
+func GetStringMap(key string) (map[string]string, error) {
+	return DefaultClient().GetStringMap(key)
+}
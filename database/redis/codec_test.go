@@ -0,0 +1,71 @@
+package redis
+
+import (
+	"time"
+
+	gc "gopkg.in/check.v1"
+)
+
+func (s *clientSuite) TestTypedGetters(c *gc.C) {
+	c.Assert(s.cli.SetValueNoExpire("i", 42), gc.IsNil)
+	i, err := s.cli.GetInt("i")
+	c.Assert(err, gc.IsNil)
+	c.Assert(i, gc.Equals, 42)
+	i64, err := s.cli.GetInt64("i")
+	c.Assert(err, gc.IsNil)
+	c.Assert(i64, gc.Equals, int64(42))
+	f, err := s.cli.GetFloat64("i")
+	c.Assert(err, gc.IsNil)
+	c.Assert(f, gc.Equals, 42.0)
+	b, err := s.cli.GetBytes("i")
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(b), gc.Equals, "42")
+
+	s.mr.RPush("l", "a", "b")
+	strs, err := s.cli.GetStrings("l")
+	c.Assert(err, gc.IsNil)
+	c.Assert(strs, gc.DeepEquals, []string{"a", "b"})
+	s.mr.SetAdd("s", "x")
+	strs, err = s.cli.GetStrings("s")
+	c.Assert(err, gc.IsNil)
+	c.Assert(strs, gc.DeepEquals, []string{"x"})
+	_, err = s.cli.GetStrings("i")
+	c.Assert(err, gc.ErrorMatches, "redis: GetStrings of string key i")
+
+	s.mr.HSet("h", "f1", "v1")
+	s.mr.HSet("h", "f2", "v2")
+	m, err := s.cli.GetStringMap("h")
+	c.Assert(err, gc.IsNil)
+	c.Assert(m, gc.DeepEquals, map[string]string{"f1": "v1", "f2": "v2"})
+
+	for _, get := range []func() error{
+		func() error { _, err := s.cli.GetInt("missing"); return err },
+		func() error { _, err := s.cli.GetBytes("missing"); return err },
+		func() error { _, err := s.cli.GetStrings("missing"); return err },
+		func() error { _, err := s.cli.GetStringMap("missing"); return err },
+		func() error { var v Mystruct; return s.cli.GetObject("missing", &v) },
+	} {
+		c.Assert(get(), gc.Equals, ErrNil)
+	}
+}
+
+func (s *clientSuite) TestObjectCodecs(c *gc.C) {
+	want := Mystruct{1, "second", 3.3333}
+	for _, codec := range []Codec{JSONCodec, GobCodec} {
+		cli, err := NewClient(SetAddr(s.mr.Addr()), SetCodec(codec))
+		c.Assert(err, gc.IsNil)
+		c.Assert(cli.SetObject("o", want, time.Minute), gc.IsNil)
+		c.Assert(s.mr.TTL("o"), gc.Equals, time.Minute)
+		var got Mystruct
+		c.Assert(cli.GetObject("o", &got), gc.IsNil)
+		c.Assert(got, gc.Equals, want)
+		cli.Close()
+	}
+
+	c.Assert(s.cli.SetObject("o", want, 0), gc.IsNil)
+	c.Assert(s.mr.TTL("o"), gc.Equals, time.Duration(0))
+	s.mr.Set("bad", "not json")
+	var got Mystruct
+	c.Assert(s.cli.GetObject("bad", &got), gc.ErrorMatches,
+		"redis: decode bad: .*")
+}
@@ -0,0 +1,55 @@
+package redis
+
+import (
+	"github.com/garyburd/redigo/redis"
+)
+
+// The counter methods take an optional expiration in seconds, applied like
+// in SetValueAndExpire: 0 means 300s. It is renewed on every call; without
+// it the expiration of the key is left unchanged.
+
+// Incr increments the counter key by one and returns the new value.
+func (c *Client) Incr(key string, expire ...int) (int64, error) {
+	return redis.Int64(c.doExpire(expire, "INCR", key))
+}
+
+// IncrBy increments the counter key by n and returns the new value.
+func (c *Client) IncrBy(key string, n int64, expire ...int) (int64, error) {
+	return redis.Int64(c.doExpire(expire, "INCRBY", key, n))
+}
+
+// Decr decrements the counter key by one and returns the new value.
+func (c *Client) Decr(key string, expire ...int) (int64, error) {
+	return redis.Int64(c.doExpire(expire, "DECR", key))
+}
+
+// DecrBy decrements the counter key by n and returns the new value.
+func (c *Client) DecrBy(key string, n int64, expire ...int) (int64, error) {
+	return redis.Int64(c.doExpire(expire, "DECRBY", key, n))
+}
+
+// IncrByFloat increments the counter key by f and returns the new value.
+func (c *Client) IncrByFloat(key string, f float64, expire ...int) (float64,
+	error) {
+	return redis.Float64(c.doExpire(expire, "INCRBYFLOAT", key, f))
+}
+
+func Incr(key string, expire ...int) (int64, error) {
+	return DefaultClient().Incr(key, expire...)
+}
+
+func IncrBy(key string, n int64, expire ...int) (int64, error) {
+	return DefaultClient().IncrBy(key, n, expire...)
+}
+
+func Decr(key string, expire ...int) (int64, error) {
+	return DefaultClient().Decr(key, expire...)
+}
+
+func DecrBy(key string, n int64, expire ...int) (int64, error) {
+	return DefaultClient().DecrBy(key, n, expire...)
+}
+
+func IncrByFloat(key string, f float64, expire ...int) (float64, error) {
+	return DefaultClient().IncrByFloat(key, f, expire...)
+}
@@ -0,0 +1,31 @@
+package redis
+
+import (
+	"time"
+
+	gc "gopkg.in/check.v1"
+)
+
+func (s *clientSuite) TestCounters(c *gc.C) {
+	n, err := s.cli.Incr("c")
+	c.Assert(err, gc.IsNil)
+	c.Assert(n, gc.Equals, int64(1))
+	c.Assert(s.mr.TTL("c"), gc.Equals, time.Duration(0))
+	n, err = s.cli.IncrBy("c", 10, 20)
+	c.Assert(err, gc.IsNil)
+	c.Assert(n, gc.Equals, int64(11))
+	c.Assert(s.mr.TTL("c"), gc.Equals, 20*time.Second)
+	n, err = s.cli.Decr("c")
+	c.Assert(err, gc.IsNil)
+	c.Assert(n, gc.Equals, int64(10))
+	n, err = s.cli.DecrBy("c", 4)
+	c.Assert(err, gc.IsNil)
+	c.Assert(n, gc.Equals, int64(6))
+	f, err := s.cli.IncrByFloat("c", 0.5)
+	c.Assert(err, gc.IsNil)
+	c.Assert(f, gc.Equals, 6.5)
+
+	s.mr.Set("s", "abc")
+	_, err = s.cli.Incr("s")
+	c.Assert(err, gc.NotNil)
+}
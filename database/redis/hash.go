@@ -0,0 +1,179 @@
+package redis
+
+import (
+	"github.com/garyburd/redigo/redis"
+)
+
+// The write methods of hashes take an optional expiration of the whole
+// hash in seconds, applied like in SetValueAndExpire: 0 means 300s.
+// Without it the expiration of the key is left unchanged.
+
+// HSet sets field of the hash key to value.
+func (c *Client) HSet(key, field string, value interface{}, expire ...int) error {
+	_, err := c.doExpire(expire, "HSET", key, field, value)
+	return err
+}
+
+// HMSet sets several fields of the hash key.
+func (c *Client) HMSet(key string, fields map[string]interface{},
+	expire ...int) error {
+	if len(fields) == 0 {
+		return nil
+	}
+	args := make([]interface{}, 0, 2*len(fields))
+	for k, v := range fields {
+		args = append(args, k, v)
+	}
+	_, err := c.doExpire(expire, "HMSET", key, args...)
+	return err
+}
+
+// HSetNX sets field of the hash key only if it does not exist yet, and
+// reports whether it was set.
+func (c *Client) HSetNX(key, field string, value interface{},
+	expire ...int) (bool, error) {
+	return redis.Bool(c.doExpire(expire, "HSETNX", key, field, value))
+}
+
+// HGet returns field of the hash key, or ErrNil if it does not exist.
+func (c *Client) HGet(key, field string) ([]byte, error) {
+	return redis.Bytes(c.do("HGET", key, field))
+}
+
+// HGetString returns field of the hash key as a string, or ErrNil if it
+// does not exist.
+func (c *Client) HGetString(key, field string) (string, error) {
+	return redis.String(c.do("HGET", key, field))
+}
+
+// HGetInt64 returns field of the hash key as an int64, or ErrNil if it
+// does not exist.
+func (c *Client) HGetInt64(key, field string) (int64, error) {
+	return redis.Int64(c.do("HGET", key, field))
+}
+
+// HMGet returns the given fields of the hash key. Missing fields are
+// absent from the map.
+func (c *Client) HMGet(key string, fields ...string) (map[string]string, error) {
+	values, err := redis.Values(c.do("HMGET", keyArgs(key, fields)...))
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[string]string, len(values))
+	for i, v := range values {
+		if v == nil {
+			continue
+		}
+		s, err := redis.String(v, nil)
+		if err != nil {
+			return nil, err
+		}
+		m[fields[i]] = s
+	}
+	return m, nil
+}
+
+// HGetAll returns all fields of the hash key, an empty map if it does not
+// exist. See GetStringMap for a variant returning ErrNil.
+func (c *Client) HGetAll(key string) (map[string]string, error) {
+	return redis.StringMap(c.do("HGETALL", key))
+}
+
+// HDel deletes fields of the hash key and returns how many existed.
+func (c *Client) HDel(key string, fields ...string) (int, error) {
+	return redis.Int(c.do("HDEL", keyArgs(key, fields)...))
+}
+
+// HExists reports whether field exists in the hash key.
+func (c *Client) HExists(key, field string) (bool, error) {
+	return redis.Bool(c.do("HEXISTS", key, field))
+}
+
+// HLen returns the number of fields of the hash key.
+func (c *Client) HLen(key string) (int, error) {
+	return redis.Int(c.do("HLEN", key))
+}
+
+// HKeys returns the field names of the hash key.
+func (c *Client) HKeys(key string) ([]string, error) {
+	return redis.Strings(c.do("HKEYS", key))
+}
+
+// HIncrBy increments field of the hash key by n and returns the new value.
+func (c *Client) HIncrBy(key, field string, n int64, expire ...int) (int64,
+	error) {
+	return redis.Int64(c.doExpire(expire, "HINCRBY", key, field, n))
+}
+
+// HIncrByFloat increments field of the hash key by f and returns the new
+// value.
+func (c *Client) HIncrByFloat(key, field string, f float64,
+	expire ...int) (float64, error) {
+	return redis.Float64(c.doExpire(expire, "HINCRBYFLOAT", key, field, f))
+}
+
+func HSet(key, field string, value interface{}, expire ...int) error {
+	return DefaultClient().HSet(key, field, value, expire...)
+}
+
+func HMSet(key string, fields map[string]interface{}, expire ...int) error {
+	return DefaultClient().HMSet(key, fields, expire...)
+}
+
+func HSetNX(key, field string, value interface{}, expire ...int) (bool, error) {
+	return DefaultClient().HSetNX(key, field, value, expire...)
+}
+
+func HGet(key, field string) ([]byte, error) {
+	return DefaultClient().HGet(key, field)
+}
+
+func HGetString(key, field string) (string, error) {
+	return DefaultClient().HGetString(key, field)
+}
+
+func HGetInt64(key, field string) (int64, error) {
+	return DefaultClient().HGetInt64(key, field)
+}
+
+func HMGet(key string, fields ...string) (map[string]string, error) {
+	return DefaultClient().HMGet(key, fields...)
+}
+
+func HGetAll(key string) (map[string]string, error) {
+	return DefaultClient().HGetAll(key)
+}
+
+func HDel(key string, fields ...string) (int, error) {
+	return DefaultClient().HDel(key, fields...)
+}
+
+func HExists(key, field string) (bool, error) {
+	return DefaultClient().HExists(key, field)
+}
+
+func HLen(key string) (int, error) {
+	return DefaultClient().HLen(key)
+}
+
+func HKeys(key string) ([]string, error) {
+	return DefaultClient().HKeys(key)
+}
+
+func HIncrBy(key, field string, n int64, expire ...int) (int64, error) {
+	return DefaultClient().HIncrBy(key, field, n, expire...)
+}
+
+func HIncrByFloat(key, field string, f float64, expire ...int) (float64, error) {
+	return DefaultClient().HIncrByFloat(key, field, f, expire...)
+}
+
+// keyArgs returns the arguments key followed by strs.
+func keyArgs(key string, strs []string) []interface{} {
+	args := make([]interface{}, 0, len(strs)+1)
+	args = append(args, key)
+	for _, s := range strs {
+		args = append(args, s)
+	}
+	return args
+}
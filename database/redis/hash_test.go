@@ -0,0 +1,55 @@
+package redis
+
+import (
+	"time"
+
+	gc "gopkg.in/check.v1"
+)
+
+func (s *clientSuite) TestHash(c *gc.C) {
+	c.Assert(s.cli.HSet("user:1", "name", "ann"), gc.IsNil)
+	c.Assert(s.mr.TTL("user:1"), gc.Equals, time.Duration(0))
+	c.Assert(s.cli.HMSet("user:1", map[string]interface{}{
+		"age": 30, "city": "rome"}, 60), gc.IsNil)
+	c.Assert(s.mr.TTL("user:1"), gc.Equals, time.Minute)
+
+	name, err := s.cli.HGetString("user:1", "name")
+	c.Assert(err, gc.IsNil)
+	c.Assert(name, gc.Equals, "ann")
+	age, err := s.cli.HGetInt64("user:1", "age")
+	c.Assert(err, gc.IsNil)
+	c.Assert(age, gc.Equals, int64(30))
+	_, err = s.cli.HGet("user:1", "missing")
+	c.Assert(err, gc.Equals, ErrNil)
+
+	m, err := s.cli.HMGet("user:1", "name", "missing", "city")
+	c.Assert(err, gc.IsNil)
+	c.Assert(m, gc.DeepEquals, map[string]string{"name": "ann", "city": "rome"})
+	all, err := s.cli.HGetAll("user:1")
+	c.Assert(err, gc.IsNil)
+	c.Assert(all, gc.HasLen, 3)
+
+	ok, err := s.cli.HSetNX("user:1", "name", "bob")
+	c.Assert(err, gc.IsNil)
+	c.Assert(ok, gc.Equals, false)
+	n, err := s.cli.HIncrBy("user:1", "age", 2, 0)
+	c.Assert(err, gc.IsNil)
+	c.Assert(n, gc.Equals, int64(32))
+	c.Assert(s.mr.TTL("user:1"), gc.Equals, 300*time.Second)
+	f, err := s.cli.HIncrByFloat("user:1", "score", 1.5)
+	c.Assert(err, gc.IsNil)
+	c.Assert(f, gc.Equals, 1.5)
+
+	exists, err := s.cli.HExists("user:1", "city")
+	c.Assert(err, gc.IsNil)
+	c.Assert(exists, gc.Equals, true)
+	deleted, err := s.cli.HDel("user:1", "city", "missing")
+	c.Assert(err, gc.IsNil)
+	c.Assert(deleted, gc.Equals, 1)
+	l, err := s.cli.HLen("user:1")
+	c.Assert(err, gc.IsNil)
+	c.Assert(l, gc.Equals, 3)
+	keys, err := s.cli.HKeys("user:1")
+	c.Assert(err, gc.IsNil)
+	c.Assert(keys, gc.HasLen, 3)
+}
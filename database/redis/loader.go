@@ -0,0 +1,323 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	mrand "math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNotFound is returned by a LoadFunc when the value does not exist. The
+// Loader caches it for NegativeTTL and returns it to the callers.
+var ErrNotFound = errors.New("redis: not found")
+
+// DefaultLoaderOptions shorten TTLs by up to 10%, cache "not found" for
+// 30s and let one process at a time load a key, holding the others back
+// for up to 2s.
+var DefaultLoaderOptions = LoaderOptions{
+	Jitter:      0.1,
+	NegativeTTL: 30 * time.Second,
+	LockTTL:     5 * time.Second,
+	LockWait:    2 * time.Second,
+}
+
+// LoaderOptions configure a Loader.
+type LoaderOptions struct {
+	// Jitter shortens each TTL by a random fraction up to Jitter, so keys
+	// cached together do not expire together.
+	Jitter float64
+	// NegativeTTL is how long ErrNotFound is cached. Zero disables
+	// negative caching.
+	NegativeTTL time.Duration
+	// StaleTTL enables stale-while-revalidate: a value is kept for
+	// StaleTTL after it expired, and a read in that window returns it at
+	// once while it is reloaded in the background.
+	StaleTTL time.Duration
+	// LockTTL is the lease of the lock guarding a load across processes.
+	// Zero disables the lock.
+	LockTTL time.Duration
+	// LockWait is how long a process waits for the value while another
+	// one holds the lock, before it loads the value itself.
+	LockWait time.Duration
+	// LoadTimeout bounds a load. A load is shared by all callers waiting
+	// for the key, so it does not end with the context of any of them.
+	// Zero means LockWait plus LockTTL, or 5s if both are zero.
+	LoadTimeout time.Duration
+}
+
+// LoadFunc loads the value of a key missing from the cache.
+type LoadFunc func(ctx context.Context) ([]byte, error)
+
+// Loader implements cache-aside with stampede protection. Concurrent
+// loads of a key are merged within the process, and a short lock on
+// <key>:lock lets a single process load it while the others wait for the
+// result.
+//
+// Values are stored with a small header recording their kind and
+// freshness, so keys written by a Loader must only be read through it.
+type Loader struct {
+	c     *Client
+	opts  LoaderOptions
+	group loadGroup
+}
+
+// NewLoader returns a Loader using opts.
+func (c *Client) NewLoader(opts LoaderOptions) *Loader {
+	if opts.Jitter < 0 || opts.Jitter >= 1 {
+		opts.Jitter = 0
+	}
+	return &Loader{c: c, opts: opts}
+}
+
+// GetOrLoad returns the value of key using DefaultLoaderOptions.
+func (c *Client) GetOrLoad(ctx context.Context, key string, ttl time.Duration,
+	loader LoadFunc) ([]byte, error) {
+	return c.defaultLoader().GetOrLoad(ctx, key, ttl, loader)
+}
+
+func (c *Client) defaultLoader() *Loader {
+	c.loaderOnce.Do(func() {
+		c.loader = c.NewLoader(DefaultLoaderOptions)
+	})
+	return c.loader
+}
+
+// GetOrLoad returns the value of key. On a miss it calls loader and caches
+// the result for ttl minus jitter. Errors of loader other than ErrNotFound
+// are returned and not cached.
+func (l *Loader) GetOrLoad(ctx context.Context, key string, ttl time.Duration,
+	loader LoadFunc) ([]byte, error) {
+	if ttl <= 0 {
+		return nil, errors.New("redis: cache TTL must be positive")
+	}
+	e, err := l.get(key)
+	if err != nil {
+		return nil, err
+	}
+	if e != nil {
+		if e.fresh() {
+			return e.value()
+		}
+		// stale: serve it and refresh in the background, unless a
+		// refresh is in flight already
+		l.group.start("refresh:"+key, func() ([]byte, error) {
+			ctx, cancel := context.WithTimeout(context.Background(),
+				l.loadTimeout())
+			defer cancel()
+			return l.load(ctx, key, ttl, loader, true)
+		})
+		return e.value()
+	}
+	// each caller stops waiting when its own ctx is done
+	return l.group.wait(ctx, key, func() ([]byte, error) {
+		ctx, cancel := context.WithTimeout(context.Background(),
+			l.loadTimeout())
+		defer cancel()
+		return l.load(ctx, key, ttl, loader, false)
+	})
+}
+
+func (l *Loader) loadTimeout() time.Duration {
+	if l.opts.LoadTimeout > 0 {
+		return l.opts.LoadTimeout
+	}
+	if d := l.opts.LockWait + l.opts.LockTTL; d > 0 {
+		return d
+	}
+	return 5 * time.Second
+}
+
+// load calls loader under the lock of key and stores the result. A
+// refresh keeps the stale value when loader fails.
+func (l *Loader) load(ctx context.Context, key string, ttl time.Duration,
+	loader LoadFunc, refresh bool) ([]byte, error) {
+	if l.opts.LockTTL > 0 {
+		lock, err := l.c.AcquireLock(ctx, key+":lock", LockOptions{
+			TTL: l.opts.LockTTL,
+		})
+		switch err {
+		case nil:
+			defer lock.Release()
+			if !refresh {
+				// filled while the lock was taken
+				e, err := l.get(key)
+				if err != nil {
+					return nil, err
+				}
+				if e != nil {
+					return e.value()
+				}
+			}
+		case ErrLockNotAcquired:
+			if refresh {
+				// someone else is refreshing it
+				return nil, nil
+			}
+			e, err := l.waitFor(ctx, key)
+			if err != nil {
+				return nil, err
+			}
+			if e != nil {
+				return e.value()
+			}
+		default:
+			return nil, err
+		}
+	}
+
+	v, err := loader(ctx)
+	switch {
+	case errors.Is(err, ErrNotFound):
+		if l.opts.NegativeTTL > 0 {
+			if err := l.set(key, kindNotFound, nil, l.opts.NegativeTTL); err != nil {
+				return nil, err
+			}
+		}
+		return nil, ErrNotFound
+	case err != nil:
+		return nil, err
+	}
+	if err := l.set(key, kindValue, v, ttl); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// waitFor polls key until another process stored it, for up to LockWait.
+func (l *Loader) waitFor(ctx context.Context, key string) (*cacheEntry,
+	error) {
+	deadline := time.Now().Add(l.opts.LockWait)
+	wait := 10 * time.Millisecond
+	for time.Now().Before(deadline) {
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		e, err := l.get(key)
+		if err != nil || e != nil {
+			return e, err
+		}
+		if wait *= 2; wait > 200*time.Millisecond {
+			wait = 200 * time.Millisecond
+		}
+	}
+	return nil, nil
+}
+
+const (
+	kindValue    = 'v'
+	kindNotFound = 'n'
+)
+
+// cacheEntry is a value stored by a Loader, encoded as its kind, the end
+// of its freshness in Unix milliseconds, a colon and the value.
+type cacheEntry struct {
+	kind       byte
+	freshUntil int64
+	data       []byte
+}
+
+func (e *cacheEntry) fresh() bool {
+	return unixMillis(time.Now()) < e.freshUntil
+}
+
+func (e *cacheEntry) value() ([]byte, error) {
+	if e.kind == kindNotFound {
+		return nil, ErrNotFound
+	}
+	return e.data, nil
+}
+
+// get returns the entry of key, nil if it is missing.
+func (l *Loader) get(key string) (*cacheEntry, error) {
+	raw, err := l.c.GetBytes(key)
+	if err == ErrNil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	i := strings.IndexByte(string(raw), ':')
+	if i < 1 {
+		return nil, errors.New("redis: invalid cache entry " + key)
+	}
+	e := &cacheEntry{kind: raw[0], data: raw[i+1:]}
+	if e.freshUntil, err = strconv.ParseInt(string(raw[1:i]), 10, 64); err != nil {
+		return nil, errors.New("redis: invalid cache entry " + key)
+	}
+	return e, nil
+}
+
+// set stores an entry fresh for the jittered ttl and kept StaleTTL longer.
+func (l *Loader) set(key string, kind byte, data []byte,
+	ttl time.Duration) error {
+	if l.opts.Jitter > 0 {
+		ttl -= time.Duration(mrand.Float64() * l.opts.Jitter * float64(ttl))
+	}
+	freshUntil := unixMillis(time.Now().Add(ttl))
+	raw := append([]byte{kind}, strconv.FormatInt(freshUntil, 10)...)
+	raw = append(append(raw, ':'), data...)
+	_, err := l.c.do("SET", key, raw, "PX", ttlMillis(ttl+l.opts.StaleTTL))
+	return err
+}
+
+// loadGroup merges concurrent loads of the same key.
+type loadGroup struct {
+	mu    sync.Mutex
+	calls map[string]*loadCall
+}
+
+type loadCall struct {
+	done chan struct{}
+	val  []byte
+	err  error
+}
+
+// wait runs fn unless a load of key is in flight, and returns its result.
+// It gives up waiting when ctx is done.
+func (g *loadGroup) wait(ctx context.Context, key string,
+	fn func() ([]byte, error)) ([]byte, error) {
+	call := g.start(key, fn)
+	select {
+	case <-call.done:
+		return call.val, call.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// start runs fn in a goroutine unless a load of key is in flight, and
+// returns the call of the load.
+func (g *loadGroup) start(key string, fn func() ([]byte, error)) *loadCall {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if call, ok := g.calls[key]; ok {
+		return call
+	}
+	if g.calls == nil {
+		g.calls = make(map[string]*loadCall)
+	}
+	call := &loadCall{done: make(chan struct{})}
+	g.calls[key] = call
+	go func() {
+		call.val, call.err = fn()
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		close(call.done)
+	}()
+	return call
+}
+
+func NewLoader(opts LoaderOptions) *Loader {
+	return DefaultClient().NewLoader(opts)
+}
+
+func GetOrLoad(ctx context.Context, key string, ttl time.Duration,
+	loader LoadFunc) ([]byte, error) {
+	return DefaultClient().GetOrLoad(ctx, key, ttl, loader)
+}
@@ -0,0 +1,159 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	gc "gopkg.in/check.v1"
+)
+
+func (s *clientSuite) TestGetOrLoad(c *gc.C) {
+	ctx := context.Background()
+	var calls int32
+	load := func(ctx context.Context) ([]byte, error) {
+		atomic.AddInt32(&calls, 1)
+		return []byte("v1"), nil
+	}
+	for i := 0; i < 2; i++ {
+		v, err := s.cli.GetOrLoad(ctx, "k", time.Minute, load)
+		c.Assert(err, gc.IsNil)
+		c.Assert(string(v), gc.Equals, "v1")
+	}
+	c.Assert(atomic.LoadInt32(&calls), gc.Equals, int32(1))
+	ttl := s.mr.TTL("k")
+	c.Assert(ttl > 50*time.Second && ttl <= time.Minute, gc.Equals, true)
+	c.Assert(s.mr.Exists("k:lock"), gc.Equals, false)
+
+	_, err := s.cli.GetOrLoad(ctx, "k", 0, load)
+	c.Assert(err, gc.NotNil)
+}
+
+func (s *clientSuite) TestGetOrLoadErrors(c *gc.C) {
+	ctx := context.Background()
+	l := s.cli.NewLoader(DefaultLoaderOptions)
+	var calls int32
+	notFound := func(ctx context.Context) ([]byte, error) {
+		atomic.AddInt32(&calls, 1)
+		return nil, ErrNotFound
+	}
+	for i := 0; i < 2; i++ {
+		_, err := l.GetOrLoad(ctx, "missing", time.Minute, notFound)
+		c.Assert(err, gc.Equals, ErrNotFound)
+	}
+	c.Assert(atomic.LoadInt32(&calls), gc.Equals, int32(1))
+	c.Assert(s.mr.TTL("missing") <= 30*time.Second, gc.Equals, true)
+
+	wrapped := func(ctx context.Context) ([]byte, error) {
+		return nil, fmt.Errorf("user 42: %w", ErrNotFound)
+	}
+	_, err := l.GetOrLoad(ctx, "user:42", time.Minute, wrapped)
+	c.Assert(err, gc.Equals, ErrNotFound)
+	c.Assert(s.mr.Exists("user:42"), gc.Equals, true)
+
+	failed := errors.New("backend down")
+	_, err = l.GetOrLoad(ctx, "broken", time.Minute,
+		func(ctx context.Context) ([]byte, error) { return nil, failed })
+	c.Assert(err, gc.Equals, failed)
+	c.Assert(s.mr.Exists("broken"), gc.Equals, false)
+}
+
+func (s *clientSuite) TestGetOrLoadStampede(c *gc.C) {
+	ctx := context.Background()
+	// two loaders stand for two processes sharing the cache
+	loaders := []*Loader{
+		s.cli.NewLoader(DefaultLoaderOptions),
+		s.cli.NewLoader(DefaultLoaderOptions),
+	}
+	var calls int32
+	load := func(ctx context.Context) ([]byte, error) {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(100 * time.Millisecond)
+		return []byte("v"), nil
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(l *Loader) {
+			defer wg.Done()
+			v, err := l.GetOrLoad(ctx, "hot", time.Minute, load)
+			c.Check(err, gc.IsNil)
+			c.Check(string(v), gc.Equals, "v")
+		}(loaders[i%2])
+	}
+	wg.Wait()
+	c.Assert(atomic.LoadInt32(&calls), gc.Equals, int32(1))
+}
+
+func (s *clientSuite) TestGetOrLoadCancelledCaller(c *gc.C) {
+	l := s.cli.NewLoader(DefaultLoaderOptions)
+	started := make(chan struct{})
+	load := func(ctx context.Context) ([]byte, error) {
+		close(started)
+		select {
+		case <-time.After(100 * time.Millisecond):
+			return []byte("v"), nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error)
+	go func() {
+		_, err := l.GetOrLoad(ctx, "k", time.Minute, load)
+		first <- err
+	}()
+	<-started
+	second := make(chan error)
+	go func() {
+		v, err := l.GetOrLoad(context.Background(), "k", time.Minute, load)
+		if err == nil && string(v) != "v" {
+			err = errors.New("unexpected value " + string(v))
+		}
+		second <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	c.Assert(<-first, gc.Equals, context.Canceled)
+	c.Assert(<-second, gc.IsNil)
+}
+
+func (s *clientSuite) TestGetOrLoadStaleWhileRevalidate(c *gc.C) {
+	ctx := context.Background()
+	opts := DefaultLoaderOptions
+	opts.StaleTTL = time.Minute
+	l := s.cli.NewLoader(opts)
+	var calls int32
+	load := func(ctx context.Context) ([]byte, error) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			return []byte("old"), nil
+		}
+		return []byte("new"), nil
+	}
+	v, err := l.GetOrLoad(ctx, "k", 20*time.Millisecond, load)
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(v), gc.Equals, "old")
+	c.Assert(s.mr.TTL("k") > time.Minute, gc.Equals, true)
+
+	time.Sleep(30 * time.Millisecond)
+	v, err = l.GetOrLoad(ctx, "k", time.Minute, load)
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(v), gc.Equals, "old")
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		v, err = l.GetOrLoad(ctx, "k", time.Minute, load)
+		c.Assert(err, gc.IsNil)
+		if string(v) == "new" {
+			break
+		}
+		if time.Now().After(deadline) {
+			c.Fatal("value not refreshed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.Assert(atomic.LoadInt32(&calls), gc.Equals, int32(2))
+}
@@ -0,0 +1,237 @@
+package redis
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	mrand "math/rand"
+	"sync"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+var (
+	// ErrLockNotAcquired is returned by AcquireLock when the lock is held
+	// by someone else until the wait timeout.
+	ErrLockNotAcquired = errors.New("redis: lock not acquired")
+	// ErrLockLost is returned when a lock expired or was taken over before
+	// it was released or extended.
+	ErrLockLost = errors.New("redis: lock lost")
+)
+
+// DefaultLockOptions hold a lock for 30s renewed every 10s, and try to
+// acquire it once.
+var DefaultLockOptions = LockOptions{
+	TTL:       30 * time.Second,
+	AutoRenew: true,
+	RetryMin:  10 * time.Millisecond,
+	RetryMax:  500 * time.Millisecond,
+}
+
+// LockOptions configure AcquireLock.
+type LockOptions struct {
+	// TTL is the lease of the lock; it expires unless extended in time.
+	TTL time.Duration
+	// AutoRenew extends the lease in the background every RenewInterval
+	// until the lock is released or lost.
+	AutoRenew bool
+	// RenewInterval defaults to a third of TTL.
+	RenewInterval time.Duration
+	// WaitTimeout is how long AcquireLock waits for a lock held by someone
+	// else. Zero tries once.
+	WaitTimeout time.Duration
+	// RetryMin and RetryMax bound the jittered exponential backoff between
+	// attempts while waiting.
+	RetryMin time.Duration
+	RetryMax time.Duration
+}
+
+var (
+	unlockScript = redis.NewScript(1, `
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+	extendScript = redis.NewScript(1, `
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0`)
+)
+
+// Lock is a distributed lock held in a Redis key. Only the holder of its
+// random token can extend or release it, so a lock that expired and was
+// taken over by someone else is never released by mistake.
+type Lock struct {
+	c     *Client
+	key   string
+	token string
+	ttl   time.Duration
+
+	lost     chan error
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// AcquireLock acquires the lock key, waiting up to opts.WaitTimeout or
+// until ctx is done while someone else holds it. If opts.AutoRenew is set
+// the lease is extended in the background until Release; watch Lost to
+// learn if it could not be extended in time.
+func (c *Client) AcquireLock(ctx context.Context, key string,
+	opts LockOptions) (*Lock, error) {
+	if opts.TTL <= 0 {
+		return nil, errors.New("redis: lock TTL must be positive")
+	}
+	if opts.RenewInterval <= 0 {
+		opts.RenewInterval = opts.TTL / 3
+	}
+	if opts.RetryMin <= 0 {
+		opts.RetryMin = DefaultLockOptions.RetryMin
+	}
+	if opts.RetryMax < opts.RetryMin {
+		opts.RetryMax = opts.RetryMin
+	}
+	l := &Lock{
+		c:     c,
+		key:   key,
+		token: newToken(),
+		ttl:   opts.TTL,
+		lost:  make(chan error, 1),
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+
+	deadline := time.Now().Add(opts.WaitTimeout)
+	wait := opts.RetryMin
+	for {
+		ok, err := l.tryAcquire()
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			break
+		}
+		// full jitter so that waiters do not retry in lockstep
+		d := time.Duration(mrand.Int63n(int64(wait)) + 1)
+		if time.Now().Add(d).After(deadline) {
+			return nil, ErrLockNotAcquired
+		}
+		select {
+		case <-time.After(d):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if wait *= 2; wait > opts.RetryMax {
+			wait = opts.RetryMax
+		}
+	}
+
+	if opts.AutoRenew {
+		go l.renew(opts.RenewInterval)
+	} else {
+		close(l.done)
+	}
+	return l, nil
+}
+
+func (l *Lock) tryAcquire() (bool, error) {
+	reply, err := l.c.do("SET", l.key, l.token, "PX", ttlMillis(l.ttl), "NX")
+	if err != nil {
+		return false, err
+	}
+	return reply != nil, nil
+}
+
+// renew extends the lease every interval. Transient errors are retried
+// until the lease would have run out.
+func (l *Lock) renew(interval time.Duration) {
+	defer close(l.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	expires := time.Now().Add(l.ttl)
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+		}
+		err := l.Extend(l.ttl)
+		if err == nil {
+			expires = time.Now().Add(l.ttl)
+			continue
+		}
+		if err == ErrLockLost || time.Now().After(expires) {
+			l.lost <- ErrLockLost
+			close(l.lost)
+			return
+		}
+	}
+}
+
+// Key returns the key of the lock.
+func (l *Lock) Key() string {
+	return l.key
+}
+
+// Token returns the random token identifying the holder.
+func (l *Lock) Token() string {
+	return l.token
+}
+
+// Lost returns a channel receiving ErrLockLost if the automatic renewal
+// failed; the work guarded by the lock should be aborted then. The channel
+// is closed afterwards or on Release.
+func (l *Lock) Lost() <-chan error {
+	return l.lost
+}
+
+// Extend resets the lease of the lock to ttl. It returns ErrLockLost if
+// the lock is no longer held.
+func (l *Lock) Extend(ttl time.Duration) error {
+	conn := l.c.pool.Get()
+	defer conn.Close()
+	n, err := redis.Int(extendScript.Do(conn, l.key, l.token, ttlMillis(ttl)))
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrLockLost
+	}
+	return nil
+}
+
+// Release stops the renewal and releases the lock if it is still held. It
+// returns ErrLockLost if the lock had expired or was taken over.
+func (l *Lock) Release() error {
+	l.stopOnce.Do(func() { close(l.stop) })
+	<-l.done
+	select {
+	case <-l.lost:
+	default:
+		close(l.lost)
+	}
+	conn := l.c.pool.Get()
+	defer conn.Close()
+	n, err := redis.Int(unlockScript.Do(conn, l.key, l.token))
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrLockLost
+	}
+	return nil
+}
+
+func newToken() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+func AcquireLock(ctx context.Context, key string, opts LockOptions) (*Lock,
+	error) {
+	return DefaultClient().AcquireLock(ctx, key, opts)
+}
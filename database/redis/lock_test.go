@@ -0,0 +1,90 @@
+package redis
+
+import (
+	"context"
+	"time"
+
+	gc "gopkg.in/check.v1"
+)
+
+func (s *clientSuite) TestLock(c *gc.C) {
+	ctx := context.Background()
+	opts := LockOptions{TTL: time.Minute}
+	l, err := s.cli.AcquireLock(ctx, "job", opts)
+	c.Assert(err, gc.IsNil)
+	v, _ := s.mr.Get("job")
+	c.Assert(v, gc.Equals, l.Token())
+	c.Assert(s.mr.TTL("job"), gc.Equals, time.Minute)
+
+	_, err = s.cli.AcquireLock(ctx, "job", opts)
+	c.Assert(err, gc.Equals, ErrLockNotAcquired)
+
+	c.Assert(l.Extend(2*time.Minute), gc.IsNil)
+	c.Assert(s.mr.TTL("job"), gc.Equals, 2*time.Minute)
+	c.Assert(l.Release(), gc.IsNil)
+	c.Assert(s.mr.Exists("job"), gc.Equals, false)
+	_, ok := <-l.Lost()
+	c.Assert(ok, gc.Equals, false)
+}
+
+func (s *clientSuite) TestLockReleaseAfterTakeover(c *gc.C) {
+	ctx := context.Background()
+	l, err := s.cli.AcquireLock(ctx, "job", LockOptions{TTL: time.Second})
+	c.Assert(err, gc.IsNil)
+	s.mr.FastForward(2 * time.Second)
+	other, err := s.cli.AcquireLock(ctx, "job", LockOptions{TTL: time.Minute})
+	c.Assert(err, gc.IsNil)
+
+	// the expired holder must not release the new holder's lock
+	c.Assert(l.Extend(time.Minute), gc.Equals, ErrLockLost)
+	c.Assert(l.Release(), gc.Equals, ErrLockLost)
+	v, _ := s.mr.Get("job")
+	c.Assert(v, gc.Equals, other.Token())
+	c.Assert(other.Release(), gc.IsNil)
+}
+
+func (s *clientSuite) TestLockWait(c *gc.C) {
+	ctx := context.Background()
+	l, err := s.cli.AcquireLock(ctx, "job", LockOptions{TTL: time.Minute})
+	c.Assert(err, gc.IsNil)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		l.Release()
+	}()
+	start := time.Now()
+	l2, err := s.cli.AcquireLock(ctx, "job", LockOptions{TTL: time.Minute,
+		WaitTimeout: 2 * time.Second, RetryMax: 20 * time.Millisecond})
+	c.Assert(err, gc.IsNil)
+	c.Assert(time.Since(start) < time.Second, gc.Equals, true)
+
+	_, err = s.cli.AcquireLock(ctx, "job", LockOptions{TTL: time.Minute,
+		WaitTimeout: 100 * time.Millisecond})
+	c.Assert(err, gc.Equals, ErrLockNotAcquired)
+
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = s.cli.AcquireLock(cctx, "job", LockOptions{TTL: time.Minute,
+		WaitTimeout: time.Second})
+	c.Assert(err, gc.Equals, context.Canceled)
+	c.Assert(l2.Release(), gc.IsNil)
+}
+
+func (s *clientSuite) TestLockRenewal(c *gc.C) {
+	ctx := context.Background()
+	l, err := s.cli.AcquireLock(ctx, "job", LockOptions{TTL: 3 * time.Second,
+		AutoRenew: true, RenewInterval: 20 * time.Millisecond})
+	c.Assert(err, gc.IsNil)
+	s.mr.SetTTL("job", time.Millisecond*50)
+	time.Sleep(100 * time.Millisecond)
+	c.Assert(s.mr.TTL("job") > time.Second, gc.Equals, true)
+
+	// somebody deletes the lock: the holder is told
+	s.mr.Del("job")
+	select {
+	case err := <-l.Lost():
+		c.Assert(err, gc.Equals, ErrLockLost)
+	case <-time.After(time.Second):
+		c.Fatal("lost lock not reported")
+	}
+	c.Assert(l.Release(), gc.Equals, ErrLockLost)
+}
@@ -0,0 +1,20 @@
+// Package msgpackcodec provides a MessagePack redis.Codec. It lives apart
+// from package redis so that only its users depend on msgpack.
+package msgpackcodec
+
+import (
+	"github.com/vmihailenco/msgpack"
+
+	"github.com/zlxtqbdgdgd/sailor/database/redis"
+)
+
+// Codec encodes objects as MessagePack, e.g. redis.SetCodec(Codec).
+var Codec redis.Codec = codec{}
+
+type codec struct{}
+
+func (codec) Marshal(v interface{}) ([]byte, error) { return msgpack.Marshal(v) }
+
+func (codec) Unmarshal(data []byte, v interface{}) error {
+	return msgpack.Unmarshal(data, v)
+}
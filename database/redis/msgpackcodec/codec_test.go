@@ -0,0 +1,26 @@
+package msgpackcodec
+
+import (
+	"testing"
+)
+
+type object struct {
+	A int
+	B string
+	C float64
+}
+
+func TestCodec(t *testing.T) {
+	want := object{1, "second", 3.3333}
+	data, err := Codec.Marshal(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got object
+	if err := Codec.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+}
@@ -0,0 +1,244 @@
+package redis
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// DefaultNearCacheOptions keep up to 10000 keys for at most 10s and
+// invalidate them through the channel "nearcache:invalidate".
+var DefaultNearCacheOptions = NearCacheOptions{
+	MaxEntries: 10000,
+	TTL:        10 * time.Second,
+	Channel:    "nearcache:invalidate",
+}
+
+// NearCacheOptions configure a NearCache.
+type NearCacheOptions struct {
+	// MaxEntries bounds the number of keys kept in memory; the least
+	// recently used one is evicted first.
+	MaxEntries int
+	// TTL is how long a value is served from memory at most. It bounds
+	// the staleness when an invalidation is lost.
+	TTL time.Duration
+	// Channel is the Pub/Sub channel of the invalidations. All instances
+	// sharing keys must use the same channel.
+	Channel string
+}
+
+// NearCacheStats are counters of a NearCache.
+type NearCacheStats struct {
+	Hits          uint64 // reads served from memory
+	Misses        uint64 // reads sent to Redis
+	Evictions     uint64 // entries dropped to respect MaxEntries
+	Invalidations uint64 // invalidation messages received
+}
+
+// NearCache is an in-process LRU cache in front of Redis for hot keys.
+// Writes through the NearCache publish the key on a Pub/Sub channel, and
+// every instance evicts it from memory on receipt. When the subscription
+// connection is lost the memory is cleared and bypassed, since
+// invalidations may be missed, until the subscription is restored; writes
+// made without the NearCache are only seen after TTL unless Invalidate is
+// called.
+type NearCache struct {
+	c    *Client
+	opts NearCacheOptions
+	sub  *Subscription
+
+	mu      sync.Mutex
+	ll      *list.List // front is most recently used
+	items   map[string]*list.Element
+	gen     uint64 // incremented by every invalidation
+	offline bool   // the subscription is reconnecting, memory is bypassed
+	stats   NearCacheStats
+}
+
+type nearEntry struct {
+	key     string
+	value   interface{}
+	expires time.Time
+}
+
+// NewNearCache returns a NearCache subscribed to its invalidation channel.
+// It must be closed after use.
+func (c *Client) NewNearCache(opts NearCacheOptions) (*NearCache, error) {
+	if opts.MaxEntries <= 0 {
+		opts.MaxEntries = DefaultNearCacheOptions.MaxEntries
+	}
+	if opts.TTL <= 0 {
+		opts.TTL = DefaultNearCacheOptions.TTL
+	}
+	if opts.Channel == "" {
+		opts.Channel = DefaultNearCacheOptions.Channel
+	}
+	nc := &NearCache{
+		c:     c,
+		opts:  opts,
+		ll:    list.New(),
+		items: make(map[string]*list.Element),
+	}
+	sub, err := c.subscribe([]string{opts.Channel}, nil, nc.setOnline)
+	if err != nil {
+		return nil, err
+	}
+	nc.sub = sub
+	go func() {
+		for m := range sub.Channel() {
+			nc.mu.Lock()
+			nc.stats.Invalidations++
+			nc.mu.Unlock()
+			nc.evict(string(m.Payload))
+		}
+	}()
+	return nc, nil
+}
+
+// GetValue returns the value of key from memory, or from Redis on a miss.
+// Missing keys are not kept in memory. The returned value is shared and
+// must not be modified.
+func (nc *NearCache) GetValue(key string) (interface{}, error) {
+	nc.mu.Lock()
+	if el, ok := nc.items[key]; ok && !nc.offline {
+		e := el.Value.(*nearEntry)
+		if time.Now().Before(e.expires) {
+			nc.ll.MoveToFront(el)
+			nc.stats.Hits++
+			nc.mu.Unlock()
+			return e.value, nil
+		}
+		nc.remove(el)
+	}
+	nc.stats.Misses++
+	gen := nc.gen
+	nc.mu.Unlock()
+
+	v, err := nc.c.GetValue(key)
+	if err != nil || v == nil {
+		return v, err
+	}
+	nc.mu.Lock()
+	// an invalidation during the read may concern the value read
+	if gen == nc.gen && !nc.offline {
+		nc.add(key, v)
+	}
+	nc.mu.Unlock()
+	return v, nil
+}
+
+// GetStringValue is GetValue converted to a string.
+func (nc *NearCache) GetStringValue(key string) (string, error) {
+	return redis.String(nc.GetValue(key))
+}
+
+// SetValue sets key to value in Redis, expiring after 300s, and
+// invalidates it on all instances.
+func (nc *NearCache) SetValue(key string, value interface{}) error {
+	return nc.SetValueAndExpire(key, value, DefaultExpire)
+}
+
+// SetValueAndExpire is SetValue expiring after expire seconds.
+func (nc *NearCache) SetValueAndExpire(key string, value interface{},
+	expire int) error {
+	if err := nc.c.SetValueAndExpire(key, value, expire); err != nil {
+		return err
+	}
+	return nc.Invalidate(key)
+}
+
+// Delete deletes key from Redis and invalidates it on all instances.
+func (nc *NearCache) Delete(key string) error {
+	if err := nc.c.Delete(key); err != nil {
+		return err
+	}
+	return nc.Invalidate(key)
+}
+
+// Invalidate evicts key from memory on all instances, e.g. after it was
+// changed without the NearCache.
+func (nc *NearCache) Invalidate(key string) error {
+	nc.evict(key)
+	_, err := nc.c.Publish(nc.opts.Channel, key)
+	return err
+}
+
+// Purge clears the memory of this instance.
+func (nc *NearCache) Purge() {
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+	nc.purge()
+}
+
+func (nc *NearCache) purge() {
+	nc.gen++
+	nc.ll.Init()
+	nc.items = make(map[string]*list.Element)
+}
+
+// setOnline is called when the subscription connection is lost and once
+// it is subscribed again.
+func (nc *NearCache) setOnline(up bool) {
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+	nc.offline = !up
+	if !up {
+		nc.purge()
+	}
+}
+
+// Len returns the number of keys in memory, including expired ones not
+// removed yet.
+func (nc *NearCache) Len() int {
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+	return nc.ll.Len()
+}
+
+// Stats returns the counters since the NearCache was created.
+func (nc *NearCache) Stats() NearCacheStats {
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+	return nc.stats
+}
+
+// Close ends the subscription and clears the memory.
+func (nc *NearCache) Close() error {
+	err := nc.sub.Close()
+	nc.Purge()
+	return err
+}
+
+func (nc *NearCache) evict(key string) {
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+	nc.gen++
+	if el, ok := nc.items[key]; ok {
+		nc.remove(el)
+	}
+}
+
+func (nc *NearCache) add(key string, value interface{}) {
+	e := &nearEntry{key: key, value: value, expires: time.Now().Add(nc.opts.TTL)}
+	if el, ok := nc.items[key]; ok {
+		el.Value = e
+		nc.ll.MoveToFront(el)
+		return
+	}
+	nc.items[key] = nc.ll.PushFront(e)
+	for nc.ll.Len() > nc.opts.MaxEntries {
+		nc.remove(nc.ll.Back())
+		nc.stats.Evictions++
+	}
+}
+
+func (nc *NearCache) remove(el *list.Element) {
+	nc.ll.Remove(el)
+	delete(nc.items, el.Value.(*nearEntry).key)
+}
+
+func NewNearCache(opts NearCacheOptions) (*NearCache, error) {
+	return DefaultClient().NewNearCache(opts)
+}
@@ -0,0 +1,132 @@
+package redis
+
+import (
+	"time"
+
+	gc "gopkg.in/check.v1"
+)
+
+func (s *clientSuite) newNearCache(c *gc.C, opts NearCacheOptions) *NearCache {
+	nc, err := s.cli.NewNearCache(opts)
+	c.Assert(err, gc.IsNil)
+	return nc
+}
+
+func (s *clientSuite) TestNearCacheHits(c *gc.C) {
+	nc := s.newNearCache(c, DefaultNearCacheOptions)
+	defer nc.Close()
+	s.mr.Set("k", "v1")
+
+	for i := 0; i < 3; i++ {
+		v, err := nc.GetStringValue("k")
+		c.Assert(err, gc.IsNil)
+		c.Assert(v, gc.Equals, "v1")
+	}
+	// served from memory although changed behind its back
+	s.mr.Set("k", "v2")
+	v, _ := nc.GetStringValue("k")
+	c.Assert(v, gc.Equals, "v1")
+
+	_, err := nc.GetValue("missing")
+	c.Assert(err, gc.IsNil)
+	c.Assert(nc.Len(), gc.Equals, 1)
+	c.Assert(nc.Stats(), gc.Equals, NearCacheStats{Hits: 3, Misses: 2})
+}
+
+func (s *clientSuite) TestNearCacheInvalidation(c *gc.C) {
+	nc1 := s.newNearCache(c, DefaultNearCacheOptions)
+	defer nc1.Close()
+	nc2 := s.newNearCache(c, DefaultNearCacheOptions)
+	defer nc2.Close()
+
+	c.Assert(nc2.SetValue("k", "v1"), gc.IsNil)
+	v, _ := nc1.GetStringValue("k")
+	c.Assert(v, gc.Equals, "v1")
+
+	c.Assert(nc2.SetValue("k", "v2"), gc.IsNil)
+	s.waitFor(c, func() bool { return nc1.Len() == 0 })
+	v, _ = nc1.GetStringValue("k")
+	c.Assert(v, gc.Equals, "v2")
+	c.Assert(nc1.Stats().Invalidations > 0, gc.Equals, true)
+
+	c.Assert(nc2.Delete("k"), gc.IsNil)
+	s.waitFor(c, func() bool { return nc1.Len() == 0 })
+	_, err := nc1.GetStringValue("k")
+	c.Assert(err, gc.Equals, ErrNil)
+}
+
+func (s *clientSuite) TestNearCacheLRUAndTTL(c *gc.C) {
+	nc := s.newNearCache(c, NearCacheOptions{MaxEntries: 2,
+		TTL: 50 * time.Millisecond})
+	defer nc.Close()
+	s.mr.Set("a", "1")
+	s.mr.Set("b", "2")
+	s.mr.Set("c", "3")
+
+	nc.GetValue("a")
+	nc.GetValue("b")
+	nc.GetValue("a")
+	nc.GetValue("c") // evicts b
+	c.Assert(nc.Len(), gc.Equals, 2)
+	nc.GetValue("a")
+	nc.GetValue("b")
+	st := nc.Stats()
+	c.Assert(st.Hits, gc.Equals, uint64(2))
+	c.Assert(st.Evictions, gc.Equals, uint64(2))
+
+	time.Sleep(60 * time.Millisecond)
+	nc.GetValue("b")
+	c.Assert(nc.Stats().Misses, gc.Equals, st.Misses+1)
+}
+
+func (s *clientSuite) TestNearCachePurgeOnDisconnect(c *gc.C) {
+	nc := s.newNearCache(c, DefaultNearCacheOptions)
+	defer nc.Close()
+	s.mr.Set("k", "v")
+	nc.GetValue("k")
+	c.Assert(nc.Len(), gc.Equals, 1)
+
+	// purged as soon as the connection is lost, not on resubscribe
+	s.mr.Close()
+	s.waitFor(c, func() bool { return nc.Len() == 0 })
+	c.Assert(s.mr.Restart(), gc.IsNil)
+	s.waitFor(c, func() bool {
+		nc.mu.Lock()
+		defer nc.mu.Unlock()
+		return !nc.offline
+	})
+	// pooled connections broken by the restart may fail the first reads
+	s.waitFor(c, func() bool {
+		nc.GetValue("k")
+		return nc.Len() == 1
+	})
+}
+
+func (s *clientSuite) TestNearCacheBypassedWhileOffline(c *gc.C) {
+	nc := s.newNearCache(c, DefaultNearCacheOptions)
+	defer nc.Close()
+	s.mr.Set("k", "v")
+	nc.setOnline(false)
+	for i := 0; i < 2; i++ {
+		v, err := nc.GetStringValue("k")
+		c.Assert(err, gc.IsNil)
+		c.Assert(v, gc.Equals, "v")
+	}
+	c.Assert(nc.Len(), gc.Equals, 0)
+	c.Assert(nc.Stats().Misses, gc.Equals, uint64(2))
+
+	nc.setOnline(true)
+	nc.GetValue("k")
+	nc.GetValue("k")
+	c.Assert(nc.Stats().Hits, gc.Equals, uint64(1))
+}
+
+func (s *clientSuite) waitFor(c *gc.C, cond func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			c.Fatal("condition not met in time")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
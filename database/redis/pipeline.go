@@ -0,0 +1,177 @@
+package redis
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// PipelineResult is the outcome of one command of a Pipeline. It is filled
+// in by Exec.
+type PipelineResult struct {
+	Cmd   string
+	Args  []interface{}
+	Reply interface{}
+	Err   error
+}
+
+// Key returns the first argument of the command, usually its key.
+func (r *PipelineResult) Key() string {
+	if len(r.Args) == 0 {
+		return ""
+	}
+	return fmt.Sprint(r.Args[0])
+}
+
+// BatchError reports the commands of a pipeline or batch that failed.
+type BatchError struct {
+	Errors map[int]error // error of each failed command by its index
+	Total  int           // number of commands sent
+
+	results []*PipelineResult
+}
+
+func (e *BatchError) Error() string {
+	indexes := make([]int, 0, len(e.Errors))
+	for i := range e.Errors {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+	msgs := make([]string, len(indexes))
+	for j, i := range indexes {
+		msgs[j] = fmt.Sprintf("#%d", i)
+		if i < len(e.results) {
+			msgs[j] += " " + e.results[i].Cmd + " " + e.results[i].Key()
+		}
+		msgs[j] += ": " + e.Errors[i].Error()
+	}
+	return fmt.Sprintf("redis: %d of %d commands failed: %s", len(e.Errors),
+		e.Total, strings.Join(msgs, "; "))
+}
+
+// Pipeline queues commands and sends them in one round trip. It is not
+// safe for concurrent use.
+type Pipeline struct {
+	c       *Client
+	results []*PipelineResult
+}
+
+// Pipeline returns a new empty Pipeline.
+func (c *Client) Pipeline() *Pipeline {
+	return &Pipeline{c: c}
+}
+
+// Queue adds a command to the pipeline and returns its result, which is
+// filled in by Exec.
+func (p *Pipeline) Queue(cmd string, args ...interface{}) *PipelineResult {
+	r := &PipelineResult{Cmd: cmd, Args: args}
+	p.results = append(p.results, r)
+	return r
+}
+
+// Len returns the number of queued commands.
+func (p *Pipeline) Len() int {
+	return len(p.results)
+}
+
+// Exec sends all queued commands in one round trip and returns their
+// results in order; the pipeline is empty afterwards. Unlike a
+// Transaction the commands are not atomic: each one succeeds or fails on
+// its own. If any failed, the error is a *BatchError, and a connection
+// error fails the remaining commands.
+func (p *Pipeline) Exec() ([]*PipelineResult, error) {
+	results := p.results
+	p.results = nil
+	if len(results) == 0 {
+		return nil, nil
+	}
+	conn := p.c.pool.Get()
+	defer conn.Close()
+	var connErr error
+	for _, r := range results {
+		if connErr = conn.Send(r.Cmd, r.Args...); connErr != nil {
+			break
+		}
+	}
+	if connErr == nil {
+		connErr = conn.Flush()
+	}
+	batchErr := &BatchError{Errors: make(map[int]error), Total: len(results),
+		results: results}
+	for i, r := range results {
+		if connErr == nil {
+			r.Reply, r.Err = conn.Receive()
+			if _, ok := r.Err.(redis.Error); !ok && r.Err != nil {
+				connErr = r.Err
+			}
+		} else {
+			r.Err = connErr
+		}
+		if r.Err != nil {
+			batchErr.Errors[i] = r.Err
+		}
+	}
+	if len(batchErr.Errors) > 0 {
+		return results, batchErr
+	}
+	return results, nil
+}
+
+// MGet returns the values of keys in one round trip. Missing keys are
+// absent from the map.
+func (c *Client) MGet(keys ...string) (map[string][]byte, error) {
+	if len(keys) == 0 {
+		return map[string][]byte{}, nil
+	}
+	values, err := redis.ByteSlices(c.do("MGET", keyArgs(keys[0], keys[1:])...))
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[string][]byte, len(values))
+	for i, v := range values {
+		if v != nil {
+			m[keys[i]] = v
+		}
+	}
+	return m, nil
+}
+
+// MSetWithTTL sets all keys to their values in one round trip, expiring
+// after ttl; zero ttl means no expiration. Without ttl the keys are set
+// atomically with MSET, otherwise each SET succeeds or fails on its own
+// and failures are reported in a *BatchError.
+func (c *Client) MSetWithTTL(values map[string]interface{},
+	ttl time.Duration) error {
+	if len(values) == 0 {
+		return nil
+	}
+	if ttl <= 0 {
+		args := make([]interface{}, 0, 2*len(values))
+		for k, v := range values {
+			args = append(args, k, v)
+		}
+		_, err := c.do("MSET", args...)
+		return err
+	}
+	p := c.Pipeline()
+	for k, v := range values {
+		p.Queue("SET", k, v, "PX", ttlMillis(ttl))
+	}
+	_, err := p.Exec()
+	return err
+}
+
+func NewPipeline() *Pipeline {
+	return DefaultClient().Pipeline()
+}
+
+func MGet(keys ...string) (map[string][]byte, error) {
+	return DefaultClient().MGet(keys...)
+}
+
+func MSetWithTTL(values map[string]interface{}, ttl time.Duration) error {
+	return DefaultClient().MSetWithTTL(values, ttl)
+}
@@ -0,0 +1,75 @@
+package redis
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	gc "gopkg.in/check.v1"
+)
+
+func (s *clientSuite) TestMGetMSet(c *gc.C) {
+	values := make(map[string]interface{})
+	for i := 0; i < 100; i++ {
+		values[fmt.Sprintf("k%d", i)] = i
+	}
+	c.Assert(s.cli.MSetWithTTL(values, time.Minute), gc.IsNil)
+	c.Assert(s.mr.TTL("k42"), gc.Equals, time.Minute)
+	c.Assert(s.cli.MSetWithTTL(map[string]interface{}{"p": "v"}, 0), gc.IsNil)
+	c.Assert(s.mr.TTL("p"), gc.Equals, time.Duration(0))
+
+	m, err := s.cli.MGet("k1", "missing", "k99", "p")
+	c.Assert(err, gc.IsNil)
+	c.Assert(m, gc.HasLen, 3)
+	c.Assert(string(m["k1"]), gc.Equals, "1")
+	c.Assert(string(m["k99"]), gc.Equals, "99")
+	c.Assert(string(m["p"]), gc.Equals, "v")
+}
+
+func (s *clientSuite) TestPipeline(c *gc.C) {
+	s.mr.Set("str", "x")
+	p := s.cli.Pipeline()
+	set := p.Queue("SET", "a", "1")
+	incr := p.Queue("INCR", "a")
+	bad := p.Queue("INCR", "str")
+	get := p.Queue("GET", "a")
+	p.Queue("HSET", "str", "f", "v")
+	c.Assert(p.Len(), gc.Equals, 5)
+
+	results, err := p.Exec()
+	c.Assert(results, gc.HasLen, 5)
+	var be *BatchError
+	c.Assert(errors.As(err, &be), gc.Equals, true)
+	c.Assert(be.Total, gc.Equals, 5)
+	// both failures of the same key are reported
+	c.Assert(be.Errors, gc.HasLen, 2)
+	c.Assert(be.Errors[2], gc.ErrorMatches, ".*not an integer.*")
+	c.Assert(be.Errors[4], gc.ErrorMatches, ".*WRONGTYPE.*")
+	c.Assert(err, gc.ErrorMatches,
+		"redis: 2 of 5 commands failed: #2 INCR str: .*; #4 HSET str: .*")
+
+	c.Assert(set.Err, gc.IsNil)
+	c.Assert(set.Reply, gc.Equals, "OK")
+	c.Assert(incr.Reply, gc.Equals, int64(2))
+	c.Assert(bad.Err, gc.NotNil)
+	c.Assert(bad.Key(), gc.Equals, "str")
+	c.Assert(string(get.Reply.([]byte)), gc.Equals, "2")
+	c.Assert(p.Len(), gc.Equals, 0)
+
+	results, err = p.Exec()
+	c.Assert(results, gc.IsNil)
+	c.Assert(err, gc.IsNil)
+}
+
+func (s *clientSuite) TestPipelineConnError(c *gc.C) {
+	p := s.cli.Pipeline()
+	p.Queue("SET", "a", "1")
+	p.Queue("SET", "b", "2")
+	s.mr.Close()
+	results, err := p.Exec()
+	c.Assert(err, gc.NotNil)
+	for _, r := range results {
+		c.Assert(r.Err, gc.NotNil)
+	}
+	c.Assert(s.mr.Restart(), gc.IsNil)
+}
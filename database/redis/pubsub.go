@@ -0,0 +1,250 @@
+package redis
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+const (
+	// DefaultPubSubHealthCheck is how often a subscription pings the
+	// server to detect broken connections.
+	DefaultPubSubHealthCheck = 30 * time.Second
+
+	minResubscribeWait = 100 * time.Millisecond
+	maxResubscribeWait = 5 * time.Second
+)
+
+var errSubscriptionClosed = errors.New("redis: subscription closed")
+
+// Message is a message received by a Subscription.
+type Message struct {
+	Channel string
+	Pattern string // matching pattern of a PSubscribe, empty otherwise
+	Payload []byte
+}
+
+// Publish posts message to channel and returns the number of subscribers
+// that received it.
+func (c *Client) Publish(channel string, message interface{}) (int, error) {
+	return redis.Int(c.do("PUBLISH", channel, message))
+}
+
+// Subscription delivers the messages of subscribed channels over a Go
+// channel. It uses a dedicated connection, and when that breaks it
+// reconnects and subscribes again with backoff; messages published in
+// between are lost, as usual with Pub/Sub.
+type Subscription struct {
+	c           *Client
+	channels    []interface{}
+	patterns    []interface{}
+	healthCheck time.Duration
+	onState     func(up bool) // called when the connection is lost or restored
+
+	msgs chan *Message
+	stop chan struct{}
+	done chan struct{}
+
+	mu     sync.Mutex
+	conn   redis.Conn
+	closed bool
+}
+
+// Subscribe subscribes to channels. The subscription is active when
+// Subscribe returns.
+func (c *Client) Subscribe(channels ...string) (*Subscription, error) {
+	return c.subscribe(channels, nil, nil)
+}
+
+// PSubscribe subscribes to the channels matching the glob patterns, e.g.
+// "cache:*".
+func (c *Client) PSubscribe(patterns ...string) (*Subscription, error) {
+	return c.subscribe(nil, patterns, nil)
+}
+
+func (c *Client) subscribe(channels, patterns []string,
+	onState func(up bool)) (*Subscription, error) {
+	if len(channels)+len(patterns) == 0 {
+		return nil, errors.New("redis: nothing to subscribe to")
+	}
+	s := &Subscription{
+		c:           c,
+		healthCheck: DefaultPubSubHealthCheck,
+		onState:     onState,
+		msgs:        make(chan *Message, 100),
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	for _, ch := range channels {
+		s.channels = append(s.channels, ch)
+	}
+	for _, p := range patterns {
+		s.patterns = append(s.patterns, p)
+	}
+	psc, err := s.connect()
+	if err != nil {
+		return nil, err
+	}
+	go s.run(psc)
+	return s, nil
+}
+
+// connect opens the connection of the subscription and waits until all
+// subscriptions are confirmed.
+func (s *Subscription) connect() (redis.PubSubConn, error) {
+	conn, err := s.c.dial()
+	if err != nil {
+		return redis.PubSubConn{}, err
+	}
+	psc := redis.PubSubConn{Conn: conn}
+	if len(s.channels) > 0 {
+		err = psc.Subscribe(s.channels...)
+	}
+	if err == nil && len(s.patterns) > 0 {
+		err = psc.PSubscribe(s.patterns...)
+	}
+	for i := 0; err == nil && i < len(s.channels)+len(s.patterns); i++ {
+		switch v := psc.ReceiveWithTimeout(s.c.readTimeout).(type) {
+		case error:
+			err = v
+		case redis.Subscription:
+		default:
+			err = errors.New("redis: unexpected reply to subscribe")
+		}
+	}
+	if err != nil {
+		conn.Close()
+		return redis.PubSubConn{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		conn.Close()
+		return redis.PubSubConn{}, errSubscriptionClosed
+	}
+	s.conn = conn
+	return psc, nil
+}
+
+// run receives messages and reconnects until the subscription is closed.
+func (s *Subscription) run(psc redis.PubSubConn) {
+	defer close(s.done)
+	defer close(s.msgs)
+	wait := minResubscribeWait
+	for {
+		err := s.receive(psc)
+		psc.Close()
+		if s.isClosed() {
+			return
+		}
+		log.Printf("redis: subscription connection lost: %v, reconnecting", err)
+		if s.onState != nil {
+			s.onState(false)
+		}
+		for {
+			select {
+			case <-time.After(wait):
+			case <-s.stop:
+				return
+			}
+			if psc, err = s.connect(); err == nil {
+				wait = minResubscribeWait
+				if s.onState != nil {
+					s.onState(true)
+				}
+				break
+			}
+			if err == errSubscriptionClosed {
+				return
+			}
+			if wait *= 2; wait > maxResubscribeWait {
+				wait = maxResubscribeWait
+			}
+		}
+	}
+}
+
+// receive forwards messages until the connection fails. A ping every
+// health check interval makes sure a silent connection is still alive.
+func (s *Subscription) receive(psc redis.PubSubConn) error {
+	pingDone := make(chan struct{})
+	defer close(pingDone)
+	go func() {
+		ticker := time.NewTicker(s.healthCheck)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if psc.Ping("") != nil {
+					return
+				}
+			case <-pingDone:
+				return
+			}
+		}
+	}()
+	for {
+		var m *Message
+		switch v := psc.ReceiveWithTimeout(2 * s.healthCheck).(type) {
+		case error:
+			return v
+		case redis.Message:
+			m = &Message{Channel: v.Channel, Payload: v.Data}
+		case redis.PMessage:
+			m = &Message{Channel: v.Channel, Pattern: v.Pattern, Payload: v.Data}
+		default:
+			continue
+		}
+		select {
+		case s.msgs <- m:
+		case <-s.stop:
+			return errSubscriptionClosed
+		}
+	}
+}
+
+func (s *Subscription) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+// Channel returns the channel delivering the messages. It is closed by
+// Close. Messages are delivered in order; a slow reader holds up the
+// subscription.
+func (s *Subscription) Channel() <-chan *Message {
+	return s.msgs
+}
+
+// Close ends the subscription and closes its connection.
+func (s *Subscription) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	close(s.stop)
+	var err error
+	if s.conn != nil {
+		err = s.conn.Close()
+	}
+	s.mu.Unlock()
+	<-s.done
+	return err
+}
+
+func Publish(channel string, message interface{}) (int, error) {
+	return DefaultClient().Publish(channel, message)
+}
+
+func Subscribe(channels ...string) (*Subscription, error) {
+	return DefaultClient().Subscribe(channels...)
+}
+
+func PSubscribe(patterns ...string) (*Subscription, error) {
+	return DefaultClient().PSubscribe(patterns...)
+}
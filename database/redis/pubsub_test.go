@@ -0,0 +1,77 @@
+package redis
+
+import (
+	"time"
+
+	gc "gopkg.in/check.v1"
+)
+
+func receive(c *gc.C, sub *Subscription) *Message {
+	select {
+	case m, ok := <-sub.Channel():
+		c.Assert(ok, gc.Equals, true)
+		return m
+	case <-time.After(2 * time.Second):
+		c.Fatal("no message received")
+	}
+	return nil
+}
+
+func (s *clientSuite) TestSubscribe(c *gc.C) {
+	sub, err := s.cli.Subscribe("news", "sport")
+	c.Assert(err, gc.IsNil)
+	defer sub.Close()
+
+	n, err := s.cli.Publish("news", "hello")
+	c.Assert(err, gc.IsNil)
+	c.Assert(n, gc.Equals, 1)
+	m := receive(c, sub)
+	c.Assert(m.Channel, gc.Equals, "news")
+	c.Assert(m.Pattern, gc.Equals, "")
+	c.Assert(string(m.Payload), gc.Equals, "hello")
+
+	s.cli.Publish("weather", "rain")
+	s.cli.Publish("sport", "goal")
+	m = receive(c, sub)
+	c.Assert(m.Channel, gc.Equals, "sport")
+
+	c.Assert(sub.Close(), gc.IsNil)
+	_, ok := <-sub.Channel()
+	c.Assert(ok, gc.Equals, false)
+	c.Assert(sub.Close(), gc.IsNil)
+}
+
+func (s *clientSuite) TestPSubscribe(c *gc.C) {
+	sub, err := s.cli.PSubscribe("cache:*")
+	c.Assert(err, gc.IsNil)
+	defer sub.Close()
+
+	s.cli.Publish("other", "x")
+	s.cli.Publish("cache:users", "42")
+	m := receive(c, sub)
+	c.Assert(m.Channel, gc.Equals, "cache:users")
+	c.Assert(m.Pattern, gc.Equals, "cache:*")
+	c.Assert(string(m.Payload), gc.Equals, "42")
+}
+
+func (s *clientSuite) TestSubscribeReconnect(c *gc.C) {
+	sub, err := s.cli.Subscribe("news")
+	c.Assert(err, gc.IsNil)
+	defer sub.Close()
+
+	s.mr.Close()
+	c.Assert(s.mr.Restart(), gc.IsNil)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		n, err := s.cli.Publish("news", "again")
+		if err == nil && n == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			c.Fatal("subscription not restored")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	m := receive(c, sub)
+	c.Assert(string(m.Payload), gc.Equals, "again")
+}
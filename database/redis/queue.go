@@ -0,0 +1,473 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// ErrNoJob is returned by Dequeue when no job arrived in time.
+var ErrNoJob = errors.New("redis: no job available")
+
+// ErrJobExpired is returned by Ack and Nack when the visibility timeout of
+// the job passed and it was already re-delivered.
+var ErrJobExpired = errors.New("redis: job visibility timeout expired")
+
+// DefaultQueueOptions re-deliver a job not acknowledged within 30s and
+// move it to the dead letters after 5 deliveries. Expired jobs are looked
+// for at most once a second.
+var DefaultQueueOptions = QueueOptions{
+	Visibility:   30 * time.Second,
+	MaxAttempts:  5,
+	ReapInterval: time.Second,
+}
+
+// consumerIdleTimeout is how long a consumer with an empty processing
+// list must not have dequeued before it is forgotten.
+const consumerIdleTimeout = time.Minute
+
+// QueueOptions configure a Queue.
+type QueueOptions struct {
+	// Visibility is how long a consumer may work on a job before it is
+	// re-delivered to another consumer.
+	Visibility time.Duration
+	// MaxAttempts is the number of deliveries after which a failed job is
+	// moved to the dead letters. Zero means no limit.
+	MaxAttempts int
+	// ReapInterval is the minimum time between two runs of RequeueExpired
+	// by Dequeue.
+	ReapInterval time.Duration
+}
+
+// Job is a message of a Queue.
+type Job struct {
+	ID       string
+	Payload  []byte
+	Attempts int // number of deliveries including the current one
+
+	consumer string
+	raw      string // encoded envelope as stored in the lists
+}
+
+// envelope is the stored form of a job.
+type envelope struct {
+	ID       string `json:"id"`
+	Payload  []byte `json:"payload"`
+	Attempts int    `json:"attempts"` // failed deliveries so far
+}
+
+func (e *envelope) encode() string {
+	raw, _ := json.Marshal(e)
+	return string(raw)
+}
+
+// Queue is a reliable work queue on Redis lists. A dequeued job moves
+// atomically to the processing list of its consumer and stays there until
+// it is acknowledged, so no job is lost when a consumer crashes: it is
+// re-delivered after the visibility timeout. Jobs failing too often end up
+// in a dead-letter list. Delayed jobs wait in a sorted set.
+//
+// The keys of a queue named q are q:ready, q:delayed, q:dead,
+// q:processing:<consumer>, q:leases, the visibility deadlines by consumer
+// and job ID, and q:consumers, the consumer names by when they last
+// dequeued.
+type Queue struct {
+	c    *Client
+	name string
+	opts QueueOptions
+
+	mu       sync.Mutex
+	nextReap time.Time
+}
+
+// NewQueue returns the Queue name.
+func (c *Client) NewQueue(name string, opts QueueOptions) *Queue {
+	if opts.Visibility <= 0 {
+		opts.Visibility = DefaultQueueOptions.Visibility
+	}
+	if opts.ReapInterval <= 0 {
+		opts.ReapInterval = DefaultQueueOptions.ReapInterval
+	}
+	return &Queue{c: c, name: name, opts: opts}
+}
+
+func (q *Queue) key(suffix string) string {
+	return q.name + ":" + suffix
+}
+
+func (q *Queue) processingKey(consumer string) string {
+	return q.name + ":processing:" + consumer
+}
+
+// Enqueue adds a job and returns its ID.
+func (q *Queue) Enqueue(payload []byte) (string, error) {
+	e := &envelope{ID: newToken(), Payload: payload}
+	_, err := q.c.do("LPUSH", q.key("ready"), e.encode())
+	return e.ID, err
+}
+
+// EnqueueDelayed adds a job delivered no earlier than after delay.
+func (q *Queue) EnqueueDelayed(payload []byte, delay time.Duration) (string,
+	error) {
+	e := &envelope{ID: newToken(), Payload: payload}
+	_, err := q.c.do("ZADD", q.key("delayed"), unixMillis(time.Now().Add(delay)),
+		e.encode())
+	return e.ID, err
+}
+
+var promoteScript = redis.NewScript(2, `
+local jobs = redis.call("ZRANGEBYSCORE", KEYS[1], "-inf", ARGV[1], "LIMIT", 0, ARGV[2])
+for _, job in ipairs(jobs) do
+	redis.call("ZREM", KEYS[1], job)
+	redis.call("LPUSH", KEYS[2], job)
+end
+return #jobs`)
+
+// moveScript removes a job from a processing list and its lease, and if it
+// was still there pushes its new envelope to a list or a sorted set.
+var moveScript = redis.NewScript(3, `
+local removed = redis.call("LREM", KEYS[1], 1, ARGV[1])
+redis.call("ZREM", KEYS[2], ARGV[2])
+if removed == 0 then
+	return 0
+end
+if ARGV[4] == "list" then
+	redis.call("LPUSH", KEYS[3], ARGV[3])
+elseif ARGV[4] == "zset" then
+	redis.call("ZADD", KEYS[3], ARGV[5], ARGV[3])
+end
+return 1`)
+
+// leaseOrphansScript gives a lease to the jobs of a processing list that
+// have none, because their consumer crashed between taking the job and
+// recording its lease. A consumer with an empty list that has not
+// dequeued since ARGV[3] is forgotten.
+var leaseOrphansScript = redis.NewScript(3, `
+local jobs = redis.call("LRANGE", KEYS[1], 0, -1)
+if #jobs == 0 then
+	local seen = redis.call("ZSCORE", KEYS[3], ARGV[1])
+	if seen and tonumber(seen) < tonumber(ARGV[3]) then
+		redis.call("ZREM", KEYS[3], ARGV[1])
+	end
+	return 0
+end
+local n = 0
+for _, job in ipairs(jobs) do
+	local id = string.match(job, '^{"id":"([^"]*)"')
+	if id then
+		n = n + redis.call("ZADD", KEYS[2], "NX", ARGV[2], ARGV[1] .. "|" .. id)
+	end
+end
+return n`)
+
+// findJobScript returns the job of a processing list whose envelope starts
+// with ARGV[2], or removes its lease ARGV[1] if it is gone.
+var findJobScript = redis.NewScript(2, `
+for _, job in ipairs(redis.call("LRANGE", KEYS[1], 0, -1)) do
+	if string.sub(job, 1, #ARGV[2]) == ARGV[2] then
+		return job
+	end
+end
+redis.call("ZREM", KEYS[2], ARGV[1])
+return false`)
+
+// Dequeue takes the next job for consumer, waiting up to block for one,
+// or until ctx is done. It returns ErrNoJob if none arrived. Delayed jobs
+// that are due and, at most once per ReapInterval, jobs whose visibility
+// timeout passed are moved to the ready list first. The job must be
+// acknowledged with Ack or Nack within the visibility timeout.
+func (q *Queue) Dequeue(ctx context.Context, consumer string,
+	block time.Duration) (*Job, error) {
+	if consumer == "" || strings.Contains(consumer, "|") {
+		return nil, errors.New("redis: invalid consumer name " + consumer)
+	}
+	if _, err := q.PromoteDelayed(); err != nil {
+		return nil, err
+	}
+	if q.reapDue() {
+		if _, err := q.RequeueExpired(); err != nil {
+			return nil, err
+		}
+	}
+
+	conn := q.c.pool.Get()
+	defer conn.Close()
+	deadline := time.Now().Add(block)
+	for {
+		// lets RequeueExpired find the processing list after a crash
+		if _, err := conn.Do("ZADD", q.key("consumers"),
+			unixMillis(time.Now()), consumer); err != nil {
+			return nil, err
+		}
+		// block at most one second at a time to notice ctx
+		secs := 0
+		if remaining := time.Until(deadline); remaining >= time.Second {
+			secs = 1
+		}
+		var reply interface{}
+		var err error
+		if secs == 0 {
+			reply, err = conn.Do("RPOPLPUSH", q.key("ready"),
+				q.processingKey(consumer))
+		} else {
+			reply, err = redis.DoWithTimeout(conn, q.c.readTimeout+time.Second,
+				"BRPOPLPUSH", q.key("ready"), q.processingKey(consumer), secs)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if reply != nil {
+			return q.lease(conn, consumer, reply)
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if !time.Now().Before(deadline) {
+			return nil, ErrNoJob
+		}
+		if secs == 0 {
+			// less than a second left: poll once more at the deadline
+			select {
+			case <-time.After(time.Until(deadline)):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+	}
+}
+
+// reapDue reports whether ReapInterval passed since the last run of
+// RequeueExpired by Dequeue.
+func (q *Queue) reapDue() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	now := time.Now()
+	if now.Before(q.nextReap) {
+		return false
+	}
+	q.nextReap = now.Add(q.opts.ReapInterval)
+	return true
+}
+
+// lease records the visibility deadline of a dequeued job.
+func (q *Queue) lease(conn redis.Conn, consumer string,
+	reply interface{}) (*Job, error) {
+	raw, err := redis.String(reply, nil)
+	if err != nil {
+		return nil, err
+	}
+	job := &Job{consumer: consumer, raw: raw}
+	var e envelope
+	if err := json.Unmarshal([]byte(raw), &e); err != nil {
+		// not a job of ours, keep it for inspection
+		conn.Do("LREM", q.processingKey(consumer), 1, raw)
+		conn.Do("LPUSH", q.key("dead"), raw)
+		return nil, errors.New("redis: invalid job in queue " + q.name)
+	}
+	job.ID, job.Payload, job.Attempts = e.ID, e.Payload, e.Attempts+1
+	deadline := unixMillis(time.Now().Add(q.opts.Visibility))
+	if _, err := conn.Do("ZADD", q.key("leases"), deadline,
+		job.leaseMember()); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+func (j *Job) leaseMember() string {
+	return j.consumer + "|" + j.ID
+}
+
+// jobPrefix returns the start of the envelope of the job id.
+func jobPrefix(id string) string {
+	return `{"id":"` + id + `"`
+}
+
+// Ack acknowledges a processed job, removing it from the queue.
+func (q *Queue) Ack(job *Job) error {
+	return q.move(job, "", "none", 0)
+}
+
+// Nack reports a failed job. It is delivered again after delay, or moved
+// to the dead letters once it was delivered MaxAttempts times.
+func (q *Queue) Nack(job *Job, delay time.Duration) error {
+	dest, mode, score := q.retryTarget(job.Attempts, delay)
+	return q.move(job, dest, mode, score)
+}
+
+// retryTarget returns where a job that failed its attempts-th delivery
+// goes.
+func (q *Queue) retryTarget(attempts int, delay time.Duration) (dest,
+	mode string, score int64) {
+	switch {
+	case q.opts.MaxAttempts > 0 && attempts >= q.opts.MaxAttempts:
+		return q.key("dead"), "list", 0
+	case delay > 0:
+		return q.key("delayed"), "zset", unixMillis(time.Now().Add(delay))
+	}
+	return q.key("ready"), "list", 0
+}
+
+func (q *Queue) move(job *Job, dest, mode string, score int64) error {
+	e := envelope{ID: job.ID, Payload: job.Payload, Attempts: job.Attempts}
+	if dest == "" {
+		dest = q.key("ready")
+	}
+	conn := q.c.pool.Get()
+	defer conn.Close()
+	n, err := redis.Int(moveScript.Do(conn, q.processingKey(job.consumer),
+		q.key("leases"), dest, job.raw, job.leaseMember(), e.encode(), mode,
+		score))
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrJobExpired
+	}
+	return nil
+}
+
+// PromoteDelayed moves the delayed jobs that are due to the ready list and
+// returns how many were moved.
+func (q *Queue) PromoteDelayed() (int, error) {
+	conn := q.c.pool.Get()
+	defer conn.Close()
+	return redis.Int(promoteScript.Do(conn, q.key("delayed"), q.key("ready"),
+		unixMillis(time.Now()), 100))
+}
+
+// RequeueExpired re-delivers the jobs whose visibility timeout passed, or
+// moves them to the dead letters, and returns how many were moved. Jobs
+// of a processing list without a lease get one expiring after the
+// visibility timeout. It reads the processing lists of all consumers, so
+// Dequeue runs it at most once per ReapInterval.
+func (q *Queue) RequeueExpired() (int, error) {
+	consumers, err := redis.Strings(q.c.do("ZRANGE", q.key("consumers"),
+		0, -1))
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now()
+	deadline := unixMillis(now.Add(q.opts.Visibility))
+	idle := unixMillis(now.Add(-consumerIdleTimeout))
+	for _, consumer := range consumers {
+		conn := q.c.pool.Get()
+		_, err := leaseOrphansScript.Do(conn, q.processingKey(consumer),
+			q.key("leases"), q.key("consumers"), consumer, deadline, idle)
+		conn.Close()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	members, err := redis.Strings(q.c.do("ZRANGEBYSCORE", q.key("leases"),
+		"-inf", unixMillis(time.Now()), "LIMIT", 0, 100))
+	if err != nil {
+		return 0, err
+	}
+	moved := 0
+	for _, m := range members {
+		i := strings.Index(m, "|")
+		if i < 0 {
+			q.c.do("ZREM", q.key("leases"), m)
+			continue
+		}
+		job := &Job{consumer: m[:i]}
+		conn := q.c.pool.Get()
+		job.raw, err = redis.String(findJobScript.Do(conn,
+			q.processingKey(job.consumer), q.key("leases"), m,
+			jobPrefix(m[i+1:])))
+		conn.Close()
+		if err == redis.ErrNil {
+			// acknowledged meanwhile
+			continue
+		}
+		if err != nil {
+			return moved, err
+		}
+		var e envelope
+		if err := json.Unmarshal([]byte(job.raw), &e); err != nil {
+			q.c.do("ZREM", q.key("leases"), m)
+			continue
+		}
+		job.ID, job.Payload, job.Attempts = e.ID, e.Payload, e.Attempts+1
+		dest, mode, score := q.retryTarget(job.Attempts, 0)
+		switch err := q.move(job, dest, mode, score); err {
+		case nil:
+			moved++
+		case ErrJobExpired:
+		default:
+			return moved, err
+		}
+	}
+	return moved, nil
+}
+
+// Recover moves the jobs left in the processing list of consumer back to
+// the ready list, e.g. when a consumer restarts after a crash, and
+// returns how many were moved. The consumer must not be running; it is
+// forgotten until it dequeues again.
+func (q *Queue) Recover(consumer string) (int, error) {
+	conn := q.c.pool.Get()
+	defer conn.Close()
+	n := 0
+	for {
+		raw, err := redis.String(conn.Do("RPOPLPUSH",
+			q.processingKey(consumer), q.key("ready")))
+		if err == redis.ErrNil {
+			break
+		}
+		if err != nil {
+			return n, err
+		}
+		var e envelope
+		if json.Unmarshal([]byte(raw), &e) == nil {
+			conn.Do("ZREM", q.key("leases"), consumer+"|"+e.ID)
+		}
+		n++
+	}
+	conn.Do("ZREM", q.key("consumers"), consumer)
+	return n, nil
+}
+
+// Len returns the number of jobs ready for delivery.
+func (q *Queue) Len() (int, error) {
+	return redis.Int(q.c.do("LLEN", q.key("ready")))
+}
+
+// DelayedLen returns the number of delayed jobs.
+func (q *Queue) DelayedLen() (int, error) {
+	return redis.Int(q.c.do("ZCARD", q.key("delayed")))
+}
+
+// DeadLetters returns up to n jobs of the dead-letter list, most recent
+// first.
+func (q *Queue) DeadLetters(n int) ([]*Job, error) {
+	raws, err := redis.Strings(q.c.do("LRANGE", q.key("dead"), 0, n-1))
+	if err != nil {
+		return nil, err
+	}
+	jobs := make([]*Job, 0, len(raws))
+	for _, raw := range raws {
+		var e envelope
+		if err := json.Unmarshal([]byte(raw), &e); err != nil {
+			jobs = append(jobs, &Job{Payload: []byte(raw), raw: raw})
+			continue
+		}
+		jobs = append(jobs, &Job{ID: e.ID, Payload: e.Payload,
+			Attempts: e.Attempts, raw: raw})
+	}
+	return jobs, nil
+}
+
+func unixMillis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+func NewQueue(name string, opts QueueOptions) *Queue {
+	return DefaultClient().NewQueue(name, opts)
+}
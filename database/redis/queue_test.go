@@ -0,0 +1,189 @@
+package redis
+
+import (
+	"context"
+	"time"
+
+	gc "gopkg.in/check.v1"
+)
+
+func (s *clientSuite) TestQueueAck(c *gc.C) {
+	ctx := context.Background()
+	q := s.cli.NewQueue("jobs", QueueOptions{Visibility: time.Minute})
+	id1, err := q.Enqueue([]byte("one"))
+	c.Assert(err, gc.IsNil)
+	_, err = q.Enqueue([]byte("two"))
+	c.Assert(err, gc.IsNil)
+
+	job, err := q.Dequeue(ctx, "w1", 0)
+	c.Assert(err, gc.IsNil)
+	c.Assert(job.ID, gc.Equals, id1)
+	c.Assert(string(job.Payload), gc.Equals, "one")
+	c.Assert(job.Attempts, gc.Equals, 1)
+	l, _ := s.mr.List("jobs:processing:w1")
+	c.Assert(l, gc.HasLen, 1)
+
+	c.Assert(q.Ack(job), gc.IsNil)
+	c.Assert(s.mr.Exists("jobs:processing:w1"), gc.Equals, false)
+	c.Assert(s.mr.Exists("jobs:leases"), gc.Equals, false)
+	c.Assert(q.Ack(job), gc.Equals, ErrJobExpired)
+
+	job, err = q.Dequeue(ctx, "w1", 0)
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(job.Payload), gc.Equals, "two")
+	_, err = q.Dequeue(ctx, "w1", 0)
+	c.Assert(err, gc.Equals, ErrNoJob)
+}
+
+func (s *clientSuite) TestQueueBlockingDequeue(c *gc.C) {
+	q := s.cli.NewQueue("jobs", DefaultQueueOptions)
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		q.Enqueue([]byte("late"))
+	}()
+	job, err := q.Dequeue(context.Background(), "w1", 3*time.Second)
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(job.Payload), gc.Equals, "late")
+
+	start := time.Now()
+	_, err = q.Dequeue(context.Background(), "w1", 300*time.Millisecond)
+	c.Assert(err, gc.Equals, ErrNoJob)
+	c.Assert(time.Since(start) >= 300*time.Millisecond, gc.Equals, true)
+}
+
+func (s *clientSuite) TestQueueNackAndDeadLetter(c *gc.C) {
+	ctx := context.Background()
+	q := s.cli.NewQueue("jobs", QueueOptions{Visibility: time.Minute,
+		MaxAttempts: 2})
+	q.Enqueue([]byte("flaky"))
+
+	job, err := q.Dequeue(ctx, "w1", 0)
+	c.Assert(err, gc.IsNil)
+	c.Assert(q.Nack(job, 0), gc.IsNil)
+	job, err = q.Dequeue(ctx, "w2", 0)
+	c.Assert(err, gc.IsNil)
+	c.Assert(job.Attempts, gc.Equals, 2)
+	c.Assert(q.Nack(job, 0), gc.IsNil)
+
+	_, err = q.Dequeue(ctx, "w1", 0)
+	c.Assert(err, gc.Equals, ErrNoJob)
+	dead, err := q.DeadLetters(10)
+	c.Assert(err, gc.IsNil)
+	c.Assert(dead, gc.HasLen, 1)
+	c.Assert(string(dead[0].Payload), gc.Equals, "flaky")
+	c.Assert(dead[0].Attempts, gc.Equals, 2)
+}
+
+func (s *clientSuite) TestQueueVisibilityTimeout(c *gc.C) {
+	ctx := context.Background()
+	q := s.cli.NewQueue("jobs", QueueOptions{
+		Visibility: 50 * time.Millisecond, ReapInterval: time.Millisecond})
+	q.Enqueue([]byte("stuck"))
+	job, err := q.Dequeue(ctx, "crashed", 0)
+	c.Assert(err, gc.IsNil)
+
+	time.Sleep(100 * time.Millisecond)
+	again, err := q.Dequeue(ctx, "w2", 0)
+	c.Assert(err, gc.IsNil)
+	c.Assert(again.ID, gc.Equals, job.ID)
+	c.Assert(again.Attempts, gc.Equals, 2)
+	c.Assert(s.mr.Exists("jobs:processing:crashed"), gc.Equals, false)
+	// the late consumer cannot acknowledge any more
+	c.Assert(q.Ack(job), gc.Equals, ErrJobExpired)
+	c.Assert(q.Ack(again), gc.IsNil)
+}
+
+func (s *clientSuite) TestQueueCrashBeforeLease(c *gc.C) {
+	ctx := context.Background()
+	q := s.cli.NewQueue("jobs", QueueOptions{
+		Visibility: 50 * time.Millisecond, ReapInterval: time.Millisecond})
+	id, _ := q.Enqueue([]byte("orphan"))
+	// the consumer registered and took the job, then crashed before
+	// recording its lease
+	s.mr.ZAdd("jobs:consumers", float64(unixMillis(time.Now())), "crashed")
+	_, err := s.cli.do("RPOPLPUSH", "jobs:ready", "jobs:processing:crashed")
+	c.Assert(err, gc.IsNil)
+	c.Assert(s.mr.Exists("jobs:leases"), gc.Equals, false)
+
+	_, err = q.Dequeue(ctx, "w2", 0)
+	c.Assert(err, gc.Equals, ErrNoJob)
+	leases, _ := s.mr.ZMembers("jobs:leases")
+	c.Assert(leases, gc.DeepEquals, []string{"crashed|" + id})
+
+	time.Sleep(100 * time.Millisecond)
+	job, err := q.Dequeue(ctx, "w2", 0)
+	c.Assert(err, gc.IsNil)
+	c.Assert(job.ID, gc.Equals, id)
+	c.Assert(job.Attempts, gc.Equals, 2)
+	c.Assert(s.mr.Exists("jobs:processing:crashed"), gc.Equals, false)
+	c.Assert(q.Ack(job), gc.IsNil)
+}
+
+func (s *clientSuite) TestQueueReapInterval(c *gc.C) {
+	ctx := context.Background()
+	q := s.cli.NewQueue("jobs", QueueOptions{
+		Visibility: 50 * time.Millisecond, ReapInterval: time.Hour})
+	q.Enqueue([]byte("stuck"))
+	_, err := q.Dequeue(ctx, "crashed", 0)
+	c.Assert(err, gc.IsNil)
+
+	time.Sleep(100 * time.Millisecond)
+	_, err = q.Dequeue(ctx, "w2", 0)
+	c.Assert(err, gc.Equals, ErrNoJob)
+	n, err := q.RequeueExpired()
+	c.Assert(err, gc.IsNil)
+	c.Assert(n, gc.Equals, 1)
+}
+
+func (s *clientSuite) TestQueueForgetsIdleConsumers(c *gc.C) {
+	q := s.cli.NewQueue("jobs", DefaultQueueOptions)
+	old := time.Now().Add(-2 * consumerIdleTimeout)
+	s.mr.ZAdd("jobs:consumers", float64(unixMillis(old)), "gone")
+	s.mr.ZAdd("jobs:consumers", float64(unixMillis(old)), "busy")
+	s.mr.Push("jobs:processing:busy", (&envelope{ID: "x"}).encode())
+	s.mr.ZAdd("jobs:consumers", float64(unixMillis(time.Now())), "idle")
+
+	_, err := q.RequeueExpired()
+	c.Assert(err, gc.IsNil)
+	consumers, _ := s.mr.ZMembers("jobs:consumers")
+	c.Assert(consumers, gc.DeepEquals, []string{"busy", "idle"})
+}
+
+func (s *clientSuite) TestQueueDelayed(c *gc.C) {
+	ctx := context.Background()
+	q := s.cli.NewQueue("jobs", DefaultQueueOptions)
+	_, err := q.EnqueueDelayed([]byte("later"), 100*time.Millisecond)
+	c.Assert(err, gc.IsNil)
+	n, _ := q.DelayedLen()
+	c.Assert(n, gc.Equals, 1)
+	_, err = q.Dequeue(ctx, "w1", 0)
+	c.Assert(err, gc.Equals, ErrNoJob)
+
+	time.Sleep(150 * time.Millisecond)
+	job, err := q.Dequeue(ctx, "w1", 0)
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(job.Payload), gc.Equals, "later")
+
+	// nack with delay goes back to the schedule
+	c.Assert(q.Nack(job, time.Hour), gc.IsNil)
+	n, _ = q.DelayedLen()
+	c.Assert(n, gc.Equals, 1)
+}
+
+func (s *clientSuite) TestQueueRecover(c *gc.C) {
+	ctx := context.Background()
+	q := s.cli.NewQueue("jobs", DefaultQueueOptions)
+	q.Enqueue([]byte("a"))
+	q.Enqueue([]byte("b"))
+	q.Dequeue(ctx, "w1", 0)
+	q.Dequeue(ctx, "w1", 0)
+	n, err := q.Recover("w1")
+	c.Assert(err, gc.IsNil)
+	c.Assert(n, gc.Equals, 2)
+	n, _ = q.Len()
+	c.Assert(n, gc.Equals, 2)
+	c.Assert(s.mr.Exists("jobs:leases"), gc.Equals, false)
+	job, err := q.Dequeue(ctx, "w1", 0)
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(job.Payload), gc.Equals, "a")
+}
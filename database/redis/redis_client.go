@@ -1,217 +1,125 @@
 package redis
 
 import (
-	"strings"
-	"sync"
-	"time"
-
+	"fmt"
 	"log"
-
-	"github.com/garyburd/redigo/redis"
-)
-
-var (
-	pool *redis.Pool
-	once sync.Once
+	"strconv"
+	"strings"
 )
 
+// ConnectInit 初始化包级函数使用的默认客户端，db为数据库编号，空串表示0。
+// 再次调用会以新的地址替换默认客户端，并关闭原来的客户端。连接服务器失败
+// （包括AUTH和SELECT失败）时返回错误，此时默认客户端保持不变。
 func ConnectInit(addr, passwd, db string) error {
-	once.Do(func() {
-		pool = &redis.Pool{
-			MaxIdle:     2,
-			MaxActive:   20,
-			IdleTimeout: 180 * time.Second,
-			Dial: func() (redis.Conn, error) {
-				conn, err := redis.Dial("tcp", addr)
-				if err != nil {
-					return nil, err
-				}
-				if strings.TrimSpace(passwd) != "" {
-					if _, err := conn.Do("AUTH", strings.TrimSpace(passwd)); err != nil {
-						conn.Close()
-						return nil, err
-					}
-				}
-				conn.Do("SELECT", db)
-				return conn, nil
-			},
+	n := 0
+	if db = strings.TrimSpace(db); db != "" {
+		var err error
+		if n, err = strconv.Atoi(db); err != nil {
+			return fmt.Errorf("redis: invalid db %q: %v", db, err)
 		}
-	})
+	}
+	c, err := NewClient(SetAddr(addr), SetPassword(passwd), SetDB(n))
+	if err != nil {
+		return err
+	}
+	if err = c.Ping(); err != nil {
+		c.Close()
+		return err
+	}
+	if old := swapDefaultClient(c); old != nil {
+		old.Close()
+	}
 	return nil
 }
 
 func GetValue(key string) (interface{}, error) {
-	redis_cli := pool.Get()
-	defer redis_cli.Close()
-	return redis_cli.Do("GET", key)
+	return DefaultClient().GetValue(key)
 }
 
 func GetStringValue(key string) (string, error) {
-	redis_cli := pool.Get()
-	defer redis_cli.Close()
-	reply, err := redis_cli.Do("GET", key)
+	v, err := DefaultClient().GetStringValue(key)
 	if err != nil {
-		log.Printf("failed to get value of key:", key, "error:", err.Error())
+		log.Printf("failed to get value of key: %s, error: %v", key, err)
 		return "", err
 	}
-	v, err := redis.String(reply, err)
-	if err != nil {
-		log.Printf("failed to convert value of key:", key, ", error:", err.Error())
-		return "", err
-	}
-
 	return v, nil
 }
 
 func SetValue(key string, value interface{}) error {
-	return SetValueAndExpire(key, value, 300)
+	return DefaultClient().SetValue(key, value)
 }
 
 func SetValueAndExpire(key string, value interface{}, expire int) error {
-	redis_cli := pool.Get()
-	defer redis_cli.Close()
-	_, err := redis_cli.Do("SET", key, value)
-	if err != nil {
-		return err
-	}
-	if expire == 0 {
-		_, err = redis_cli.Do("EXPIRE", key, 300)
-	} else {
-		_, err = redis_cli.Do("EXPIRE", key, expire)
-	}
-	return err
+	return DefaultClient().SetValueAndExpire(key, value, expire)
 }
 
 func SetValueNoExpire(key string, value interface{}) error {
-	redis_cli := pool.Get()
-	defer redis_cli.Close()
-	_, err := redis_cli.Do("SET", key, value)
-	return err
+	return DefaultClient().SetValueNoExpire(key, value)
 }
 
-//设置key，仅当key不存在时成功，同时设置过期时间，def为"px"表示毫秒，"ex"表示秒
-//设置成功返回“OK”，否则返回nil
+// 设置key，仅当key不存在时成功，同时设置过期时间，def为"px"表示毫秒，"ex"表示秒
+// 设置成功返回“OK”，否则返回nil
 func SetNxKeyAndExpire(key string, value interface{}, def string, expire int) (interface{}, error) {
-	redis_cli := pool.Get()
-	defer redis_cli.Close()
-	return redis_cli.Do("SET", key, value, def, expire, "nx")
+	return DefaultClient().SetNxKeyAndExpire(key, value, def, expire)
 }
 
 func SetExpire(key string, expire ...int) error {
-	redis_cli := pool.Get()
-	defer redis_cli.Close()
-	tExpire := 300
-	if len(expire) > 0 {
-		tExpire = expire[0]
-	}
-	_, err := redis_cli.Do("EXPIRE", key, tExpire)
-	return err
+	return DefaultClient().SetExpire(key, expire...)
 }
 
 func Delete(key string) error {
-	redis_cli := pool.Get()
-	defer redis_cli.Close()
-	_, err := redis_cli.Do("DEL", key)
-	return err
+	return DefaultClient().Delete(key)
 }
 
-//设置redis中的set中的值
+// 设置redis中的set中的值
 func SetSetValue(key string, value interface{}) error {
-	redis_cli := pool.Get()
-	defer redis_cli.Close()
-	_, err := redis_cli.Do("SADD", key, value)
-	if err != nil {
-		return err
-	}
-	_, err = redis_cli.Do("EXPIRE", key, 300)
-	return err
+	return DefaultClient().SetSetValue(key, value)
 }
 
-//判断某个值是否在set中
+// 判断某个值是否在set中
 func IsSetMember(key string, value interface{}) bool {
-	redis_cli := pool.Get()
-	defer redis_cli.Close()
-	exist, err := redis.Bool(redis_cli.Do("SISMEMBER", key, value))
-	if err != nil {
-		return false
-	}
-	return exist
+	return DefaultClient().IsSetMember(key, value)
 }
 
-//获取set集合中的所有值
+// 获取set集合中的所有值
 func GetSetAllValue(key string) (interface{}, error) {
-	redis_cli := pool.Get()
-	defer redis_cli.Close()
-	return redis_cli.Do("SMEMBERS", key)
+	return DefaultClient().GetSetAllValue(key)
 }
 
 func ExistKey(key string) bool {
-	redis_cli := pool.Get()
-	defer redis_cli.Close()
-	exist, err := redis.Bool(redis_cli.Do("EXISTS", key))
-	if err != nil {
-		return false
-	}
-	return exist
+	return DefaultClient().ExistKey(key)
 }
 
-//获取集合中元素的个数
+// 获取集合中元素的个数
 func GetLenOfSet(key string) (int, error) {
-	redis_cli := pool.Get()
-	defer redis_cli.Close()
-	return redis.Int(redis_cli.Do("scard", key))
+	return DefaultClient().GetLenOfSet(key)
 }
 
-//获取链表中元素的个数
+// 获取链表中元素的个数
 func GetLenOfList(key string) (int, error) {
-	redis_cli := pool.Get()
-	defer redis_cli.Close()
-	return redis.Int(redis_cli.Do("llen", key))
+	return DefaultClient().GetLenOfList(key)
 }
 
-//从链表尾处插入元素，用户依据相应场景设置自身的过期时间
+// 从链表尾处插入元素，用户依据相应场景设置自身的过期时间
 func PushElementWithTail(key string, value interface{}, expire int) error {
-	redis_cli := pool.Get()
-	defer redis_cli.Close()
-	_, err := redis_cli.Do("rpush", key, value)
-	if err != nil {
-		return err
-	}
-	if expire == 0 {
-		_, err = redis_cli.Do("EXPIRE", key, 300)
-	} else {
-		/*expire_time,_:=strconv.Atoi(expire)*/
-		_, err = redis_cli.Do("EXPIRE", key, expire)
-	}
-	return err
+	return DefaultClient().PushElementWithTail(key, value, expire)
 }
 
-//从链表头位置删除元素
+// 从链表头位置删除元素
 func PopElementFromHead(key string) (interface{}, error) {
-	redis_cli := pool.Get()
-	defer redis_cli.Close()
-	return redis_cli.Do("lpop", key)
+	return DefaultClient().PopElementFromHead(key)
 }
 
 func GetAllElementsFromList(key string) (interface{}, error) {
-	redis_cli := pool.Get()
-	defer redis_cli.Close()
-	return redis_cli.Do("lrange", key, 0, -1)
+	return DefaultClient().GetAllElementsFromList(key)
 }
 
-//按照用户所设定的过期时间进行处理
+// 按照用户所设定的过期时间进行处理
 func SetValueBasedOnExpire(key string, value interface{}, expire int) error {
-	return SetValueAndExpire(key, value, expire)
+	return DefaultClient().SetValueBasedOnExpire(key, value, expire)
 }
 
-//按照用户所设定的过期时间，对集合中的元素进行处理
+// 按照用户所设定的过期时间，对集合中的元素进行处理
 func SetSetValueBasedOnExpire(key string, value interface{}, expire int) error {
-	redis_cli := pool.Get()
-	defer redis_cli.Close()
-	_, err := redis_cli.Do("SADD", key, value)
-	if err != nil {
-		return err
-	}
-	_, err = redis_cli.Do("EXPIRE", key, expire)
-	return err
+	return DefaultClient().SetSetValueBasedOnExpire(key, value, expire)
 }
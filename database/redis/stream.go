@@ -0,0 +1,456 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// DefaultStreamOptions read 10 messages at a time and claim messages that
+// another consumer did not acknowledge within a minute.
+var DefaultStreamOptions = StreamOptions{
+	Count:         10,
+	Block:         5 * time.Second,
+	ClaimMinIdle:  time.Minute,
+	ClaimInterval: 30 * time.Second,
+	MaxDeliveries: 5,
+}
+
+// StreamOptions configure ConsumeStream.
+type StreamOptions struct {
+	// Count is the maximum number of messages read at once.
+	Count int
+	// Block is how long a read waits for new messages.
+	Block time.Duration
+	// ClaimMinIdle is how long a message stays pending before any
+	// consumer of the group may claim it, e.g. after a crash or a failed
+	// handler.
+	ClaimMinIdle time.Duration
+	// ClaimInterval is how often pending messages are checked.
+	ClaimInterval time.Duration
+	// MaxDeliveries is the number of deliveries after which a message is
+	// moved to the stream <stream>:dead and acknowledged. Zero means no
+	// limit.
+	MaxDeliveries int
+}
+
+// StreamMessage is an entry of a stream.
+type StreamMessage struct {
+	ID     string
+	Values map[string]string
+}
+
+// PendingMessage is a message delivered to a consumer of a group but not
+// acknowledged yet.
+type PendingMessage struct {
+	ID         string
+	Consumer   string
+	Idle       time.Duration // time since the last delivery
+	Deliveries int
+}
+
+// StreamHandler processes a message of ConsumeStream. The message is
+// acknowledged when it returns nil.
+type StreamHandler func(ctx context.Context, msg *StreamMessage) error
+
+// XAdd appends values to stream and returns the ID of the new entry. If
+// maxLen is greater than zero the stream is trimmed to about maxLen
+// entries.
+func (c *Client) XAdd(stream string, maxLen int,
+	values map[string]interface{}) (string, error) {
+	if len(values) == 0 {
+		return "", errors.New("redis: no values to add to " + stream)
+	}
+	args := redis.Args{stream}
+	if maxLen > 0 {
+		args = args.Add("MAXLEN", "~", maxLen)
+	}
+	args = args.Add("*")
+	fields := make([]string, 0, len(values))
+	for f := range values {
+		fields = append(fields, f)
+	}
+	sort.Strings(fields)
+	for _, f := range fields {
+		args = args.Add(f, values[f])
+	}
+	return redis.String(c.do("XADD", args...))
+}
+
+// XLen returns the number of entries of stream.
+func (c *Client) XLen(stream string) (int, error) {
+	return redis.Int(c.do("XLEN", stream))
+}
+
+// XGroupCreate creates the consumer group of stream, starting after the
+// entry start ("$" for new entries only, "0" for all). The stream is
+// created if needed. An existing group is not an error.
+func (c *Client) XGroupCreate(stream, group, start string) error {
+	_, err := c.do("XGROUP", "CREATE", stream, group, start, "MKSTREAM")
+	if err != nil && strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return nil
+	}
+	return err
+}
+
+// XReadGroup reads up to count new messages of stream for consumer of
+// group, waiting up to block for them. It returns no messages and no
+// error if none arrived.
+func (c *Client) XReadGroup(stream, group, consumer string, count int,
+	block time.Duration) ([]*StreamMessage, error) {
+	return c.xReadGroup(stream, group, consumer, ">", count, block)
+}
+
+// xReadGroup reads the messages after id; ">" reads new messages, any
+// other id the pending messages of consumer.
+func (c *Client) xReadGroup(stream, group, consumer, id string, count int,
+	block time.Duration) ([]*StreamMessage, error) {
+	args := redis.Args{"GROUP", group, consumer}
+	if count > 0 {
+		args = args.Add("COUNT", count)
+	}
+	if block > 0 {
+		args = args.Add("BLOCK", int64(block/time.Millisecond))
+	}
+	args = args.Add("STREAMS", stream, id)
+
+	conn := c.pool.Get()
+	defer conn.Close()
+	reply, err := redis.DoWithTimeout(conn, c.readTimeout+block,
+		"XREADGROUP", args...)
+	if err != nil || reply == nil {
+		return nil, err
+	}
+	streams, err := redis.Values(reply, nil)
+	if err != nil || len(streams) == 0 {
+		return nil, err
+	}
+	s, err := redis.Values(streams[0], nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(s) != 2 {
+		return nil, errors.New("redis: unexpected XREADGROUP reply")
+	}
+	return streamMessages(s[1])
+}
+
+// streamMessages parses a list of entries. Entries deleted while pending
+// have nil values.
+func streamMessages(reply interface{}) ([]*StreamMessage, error) {
+	entries, err := redis.Values(reply, nil)
+	if err != nil {
+		return nil, err
+	}
+	msgs := make([]*StreamMessage, 0, len(entries))
+	for _, e := range entries {
+		entry, err := redis.Values(e, nil)
+		if err != nil {
+			return nil, err
+		}
+		if len(entry) != 2 {
+			return nil, errors.New("redis: unexpected stream entry")
+		}
+		id, err := redis.String(entry[0], nil)
+		if err != nil {
+			return nil, err
+		}
+		m := &StreamMessage{ID: id}
+		if entry[1] != nil {
+			if m.Values, err = redis.StringMap(entry[1], nil); err != nil {
+				return nil, err
+			}
+		}
+		msgs = append(msgs, m)
+	}
+	return msgs, nil
+}
+
+// XAck acknowledges the messages of group and returns how many were
+// pending.
+func (c *Client) XAck(stream, group string, ids ...string) (int, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	return redis.Int(c.do("XACK", redis.Args{stream, group}.AddFlat(ids)...))
+}
+
+// XPending returns up to count pending messages of group, oldest first.
+func (c *Client) XPending(stream, group string, count int) ([]*PendingMessage,
+	error) {
+	return c.xPending(stream, group, "-", count)
+}
+
+// xPending returns up to count pending messages of group from the ID
+// start on.
+func (c *Client) xPending(stream, group, start string, count int) (
+	[]*PendingMessage, error) {
+	reply, err := redis.Values(c.do("XPENDING", stream, group, start, "+",
+		count))
+	if err == redis.ErrNil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	pending := make([]*PendingMessage, 0, len(reply))
+	for _, r := range reply {
+		var p PendingMessage
+		var idle int64
+		values, err := redis.Values(r, nil)
+		if err == nil {
+			_, err = redis.Scan(values, &p.ID, &p.Consumer, &idle, &p.Deliveries)
+		}
+		if err != nil {
+			return nil, err
+		}
+		p.Idle = time.Duration(idle) * time.Millisecond
+		pending = append(pending, &p)
+	}
+	return pending, nil
+}
+
+// XClaim transfers the messages pending for at least minIdle to consumer
+// and returns them. Messages claimed in the meantime by someone else are
+// left out.
+func (c *Client) XClaim(stream, group, consumer string, minIdle time.Duration,
+	ids ...string) ([]*StreamMessage, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	args := redis.Args{stream, group, consumer, int64(minIdle / time.Millisecond)}
+	reply, err := c.do("XCLAIM", args.AddFlat(ids)...)
+	if err != nil {
+		return nil, err
+	}
+	return streamMessages(reply)
+}
+
+// ConsumeStream runs handler for the messages of stream as consumer of
+// group until ctx is done or Redis fails. The group is created if needed.
+// It first handles the messages still pending for consumer, e.g. from
+// before a crash, then new messages. Every ClaimInterval it claims the
+// messages of the group pending for longer than ClaimMinIdle, which
+// retries failed messages and takes over those of dead consumers.
+func (c *Client) ConsumeStream(ctx context.Context, stream, group,
+	consumer string, opts StreamOptions, handler StreamHandler) error {
+	if opts.Count <= 0 {
+		opts.Count = DefaultStreamOptions.Count
+	}
+	if opts.ClaimMinIdle <= 0 {
+		opts.ClaimMinIdle = DefaultStreamOptions.ClaimMinIdle
+	}
+	if opts.ClaimInterval <= 0 {
+		opts.ClaimInterval = DefaultStreamOptions.ClaimInterval
+	}
+	if err := c.XGroupCreate(stream, group, "$"); err != nil {
+		return err
+	}
+	sc := &streamConsumer{c: c, stream: stream, group: group,
+		consumer: consumer, opts: opts, handler: handler}
+
+	for id := "0"; ; {
+		msgs, err := c.xReadGroup(stream, group, consumer, id, opts.Count, 0)
+		if err != nil {
+			return err
+		}
+		if len(msgs) == 0 {
+			break
+		}
+		for _, m := range msgs {
+			if err := sc.handle(ctx, m); err != nil {
+				return err
+			}
+		}
+		id = msgs[len(msgs)-1].ID
+	}
+
+	lastClaim := time.Now()
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if time.Since(lastClaim) >= opts.ClaimInterval {
+			if err := sc.claim(ctx); err != nil {
+				return err
+			}
+			lastClaim = time.Now()
+		}
+		// block at most one second at a time to notice ctx
+		block := opts.Block
+		if block <= 0 || block > time.Second {
+			block = time.Second
+		}
+		if wait := opts.ClaimInterval - time.Since(lastClaim); wait < block {
+			block = wait
+		}
+		if block < time.Millisecond {
+			block = time.Millisecond
+		}
+		msgs, err := c.XReadGroup(stream, group, consumer, opts.Count, block)
+		if err != nil {
+			return err
+		}
+		for _, m := range msgs {
+			if err := sc.handle(ctx, m); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+type streamConsumer struct {
+	c                       *Client
+	stream, group, consumer string
+	opts                    StreamOptions
+	handler                 StreamHandler
+}
+
+// handle runs the handler and acknowledges the message on success. A
+// failed message stays pending and is retried when it is claimed. Only
+// Redis errors are returned.
+func (sc *streamConsumer) handle(ctx context.Context, m *StreamMessage) error {
+	if m.Values == nil {
+		// deleted from the stream while pending
+		_, err := sc.c.XAck(sc.stream, sc.group, m.ID)
+		return err
+	}
+	if err := sc.handler(ctx, m); err != nil {
+		log.Printf("redis: message %s of stream %s failed: %v", m.ID,
+			sc.stream, err)
+		return nil
+	}
+	_, err := sc.c.XAck(sc.stream, sc.group, m.ID)
+	return err
+}
+
+// claim takes over the messages pending for longer than ClaimMinIdle and
+// handles them, moving those delivered too often to the dead letters.
+//
+// The pending messages are read a page of Count at a time, so messages
+// that keep failing do not hide the ones behind them.
+func (sc *streamConsumer) claim(ctx context.Context) error {
+	for start := "-"; ; {
+		pending, err := sc.c.xPending(sc.stream, sc.group, start,
+			sc.opts.Count)
+		if err != nil {
+			return err
+		}
+		if err := sc.claimPage(ctx, pending); err != nil {
+			return err
+		}
+		if len(pending) < sc.opts.Count || ctx.Err() != nil {
+			return nil
+		}
+		if start, err = nextStreamID(pending[len(pending)-1].ID); err != nil {
+			return err
+		}
+	}
+}
+
+// nextStreamID returns the smallest ID after id.
+func nextStreamID(id string) (string, error) {
+	i := strings.IndexByte(id, '-')
+	if i < 0 {
+		return "", errors.New("redis: invalid stream ID " + id)
+	}
+	seq, err := strconv.ParseUint(id[i+1:], 10, 64)
+	if err != nil {
+		return "", errors.New("redis: invalid stream ID " + id)
+	}
+	return id[:i+1] + strconv.FormatUint(seq+1, 10), nil
+}
+
+// claimPage claims and handles the messages of one page of pending
+// messages.
+func (sc *streamConsumer) claimPage(ctx context.Context,
+	pending []*PendingMessage) error {
+	var ids []string
+	for _, p := range pending {
+		if p.Idle < sc.opts.ClaimMinIdle {
+			continue
+		}
+		if sc.opts.MaxDeliveries > 0 && p.Deliveries >= sc.opts.MaxDeliveries {
+			if err := sc.deadLetter(p); err != nil {
+				return err
+			}
+			continue
+		}
+		ids = append(ids, p.ID)
+	}
+	msgs, err := sc.c.XClaim(sc.stream, sc.group, sc.consumer,
+		sc.opts.ClaimMinIdle, ids...)
+	if err != nil {
+		return err
+	}
+	for _, m := range msgs {
+		if err := sc.handle(ctx, m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deadLetter copies the message to <stream>:dead and acknowledges it, if
+// it is still pending for longer than ClaimMinIdle.
+func (sc *streamConsumer) deadLetter(p *PendingMessage) error {
+	msgs, err := sc.c.XClaim(sc.stream, sc.group, sc.consumer,
+		sc.opts.ClaimMinIdle, p.ID)
+	if err != nil || len(msgs) == 0 {
+		return err
+	}
+	if m := msgs[0]; m.Values != nil {
+		values := map[string]interface{}{"id": m.ID}
+		for k, v := range m.Values {
+			values["value:"+k] = v
+		}
+		if _, err := sc.c.XAdd(sc.stream+":dead", 0, values); err != nil {
+			return err
+		}
+	}
+	_, err = sc.c.XAck(sc.stream, sc.group, p.ID)
+	return err
+}
+
+func XAdd(stream string, maxLen int, values map[string]interface{}) (string,
+	error) {
+	return DefaultClient().XAdd(stream, maxLen, values)
+}
+
+func XLen(stream string) (int, error) {
+	return DefaultClient().XLen(stream)
+}
+
+func XGroupCreate(stream, group, start string) error {
+	return DefaultClient().XGroupCreate(stream, group, start)
+}
+
+func XReadGroup(stream, group, consumer string, count int,
+	block time.Duration) ([]*StreamMessage, error) {
+	return DefaultClient().XReadGroup(stream, group, consumer, count, block)
+}
+
+func XAck(stream, group string, ids ...string) (int, error) {
+	return DefaultClient().XAck(stream, group, ids...)
+}
+
+func XPending(stream, group string, count int) ([]*PendingMessage, error) {
+	return DefaultClient().XPending(stream, group, count)
+}
+
+func XClaim(stream, group, consumer string, minIdle time.Duration,
+	ids ...string) ([]*StreamMessage, error) {
+	return DefaultClient().XClaim(stream, group, consumer, minIdle, ids...)
+}
+
+func ConsumeStream(ctx context.Context, stream, group, consumer string,
+	opts StreamOptions, handler StreamHandler) error {
+	return DefaultClient().ConsumeStream(ctx, stream, group, consumer, opts,
+		handler)
+}
@@ -0,0 +1,163 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	gc "gopkg.in/check.v1"
+)
+
+func (s *clientSuite) TestStreamGroup(c *gc.C) {
+	c.Assert(s.cli.XGroupCreate("events", "g", "$"), gc.IsNil)
+	c.Assert(s.cli.XGroupCreate("events", "g", "$"), gc.IsNil)
+	id1, err := s.cli.XAdd("events", 0, map[string]interface{}{"n": 1})
+	c.Assert(err, gc.IsNil)
+	_, err = s.cli.XAdd("events", 0, map[string]interface{}{"n": 2})
+	c.Assert(err, gc.IsNil)
+	n, _ := s.cli.XLen("events")
+	c.Assert(n, gc.Equals, 2)
+
+	msgs, err := s.cli.XReadGroup("events", "g", "c1", 1, 0)
+	c.Assert(err, gc.IsNil)
+	c.Assert(msgs, gc.HasLen, 1)
+	c.Assert(msgs[0].ID, gc.Equals, id1)
+	c.Assert(msgs[0].Values, gc.DeepEquals, map[string]string{"n": "1"})
+
+	pending, err := s.cli.XPending("events", "g", 10)
+	c.Assert(err, gc.IsNil)
+	c.Assert(pending, gc.HasLen, 1)
+	c.Assert(pending[0].ID, gc.Equals, id1)
+	c.Assert(pending[0].Consumer, gc.Equals, "c1")
+	c.Assert(pending[0].Deliveries, gc.Equals, 1)
+
+	claimed, err := s.cli.XClaim("events", "g", "c2", 0, id1)
+	c.Assert(err, gc.IsNil)
+	c.Assert(claimed, gc.HasLen, 1)
+	pending, _ = s.cli.XPending("events", "g", 10)
+	c.Assert(pending[0].Consumer, gc.Equals, "c2")
+	c.Assert(pending[0].Deliveries, gc.Equals, 2)
+
+	acked, err := s.cli.XAck("events", "g", id1)
+	c.Assert(err, gc.IsNil)
+	c.Assert(acked, gc.Equals, 1)
+	pending, _ = s.cli.XPending("events", "g", 10)
+	c.Assert(pending, gc.HasLen, 0)
+
+	msgs, err = s.cli.XReadGroup("events", "g", "c1", 10, 0)
+	c.Assert(err, gc.IsNil)
+	c.Assert(msgs, gc.HasLen, 1)
+	msgs, err = s.cli.XReadGroup("events", "g", "c1", 10, 10*time.Millisecond)
+	c.Assert(err, gc.IsNil)
+	c.Assert(msgs, gc.HasLen, 0)
+}
+
+func (s *clientSuite) TestConsumeStream(c *gc.C) {
+	c.Assert(s.cli.XGroupCreate("events", "g", "$"), gc.IsNil)
+	// pending for c1 from before a restart
+	s.cli.XAdd("events", 0, map[string]interface{}{"n": "old"})
+	_, err := s.cli.XReadGroup("events", "g", "c1", 10, 0)
+	c.Assert(err, gc.IsNil)
+	s.cli.XAdd("events", 0, map[string]interface{}{"n": "flaky"})
+	s.cli.XAdd("events", 0, map[string]interface{}{"n": "poison"})
+
+	var mu sync.Mutex
+	var handled []string
+	failures := 0
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	opts := StreamOptions{
+		Block:         50 * time.Millisecond,
+		ClaimMinIdle:  20 * time.Millisecond,
+		ClaimInterval: 20 * time.Millisecond,
+		MaxDeliveries: 3,
+	}
+	done := make(chan error)
+	go func() {
+		done <- s.cli.ConsumeStream(ctx, "events", "g", "c1", opts,
+			func(ctx context.Context, m *StreamMessage) error {
+				mu.Lock()
+				defer mu.Unlock()
+				switch n := m.Values["n"]; {
+				case n == "poison", n == "flaky" && failures == 0:
+					failures++
+					return errors.New("failed")
+				default:
+					handled = append(handled, n)
+					return nil
+				}
+			})
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		n, _ := s.cli.XLen("events:dead")
+		pending, _ := s.cli.XPending("events", "g", 10)
+		if n == 1 && len(pending) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			c.Fatal("stream not consumed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	c.Assert(<-done, gc.Equals, context.Canceled)
+	mu.Lock()
+	c.Assert(handled, gc.DeepEquals, []string{"old", "flaky"})
+	mu.Unlock()
+}
+
+func (s *clientSuite) TestConsumeStreamClaimsBehindPoison(c *gc.C) {
+	c.Assert(s.cli.XGroupCreate("events", "g", "$"), gc.IsNil)
+	// a dead consumer left more poison messages than Count pending, in
+	// front of a good one
+	for _, n := range []string{"poison", "poison", "poison", "good"} {
+		s.cli.XAdd("events", 0, map[string]interface{}{"n": n})
+	}
+	_, err := s.cli.XReadGroup("events", "g", "dead", 10, 0)
+	c.Assert(err, gc.IsNil)
+
+	var mu sync.Mutex
+	var handled []string
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	opts := StreamOptions{
+		Count:         2,
+		Block:         20 * time.Millisecond,
+		ClaimMinIdle:  10 * time.Millisecond,
+		ClaimInterval: 10 * time.Millisecond,
+	}
+	done := make(chan error)
+	go func() {
+		done <- s.cli.ConsumeStream(ctx, "events", "g", "c1", opts,
+			func(ctx context.Context, m *StreamMessage) error {
+				if m.Values["n"] == "poison" {
+					return errors.New("failed")
+				}
+				mu.Lock()
+				handled = append(handled, m.Values["n"])
+				mu.Unlock()
+				return nil
+			})
+	}()
+
+	s.waitFor(c, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(handled) == 1
+	})
+	cancel()
+	c.Assert(<-done, gc.Equals, context.Canceled)
+	pending, _ := s.cli.XPending("events", "g", 10)
+	c.Assert(pending, gc.HasLen, 3)
+}
+
+func (s *clientSuite) TestNextStreamID(c *gc.C) {
+	id, err := nextStreamID("1526919030474-55")
+	c.Assert(err, gc.IsNil)
+	c.Assert(id, gc.Equals, "1526919030474-56")
+	_, err = nextStreamID("bad")
+	c.Assert(err, gc.NotNil)
+}
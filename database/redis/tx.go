@@ -0,0 +1,129 @@
+package redis
+
+import (
+	"errors"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// DefaultTxMaxRetries is how often Transaction retries after a conflict.
+const DefaultTxMaxRetries = 10
+
+// ErrTxConflict is returned by Transaction when the watched keys kept
+// changing during all attempts.
+var ErrTxConflict = errors.New("redis: transaction conflict, watched keys changed")
+
+// SetTxMaxRetries sets how often Transaction retries after a conflict.
+func SetTxMaxRetries(n int) ClientOptionFunc {
+	return func(c *Client) error {
+		if n < 0 {
+			return errors.New("redis: negative transaction retries")
+		}
+		c.txMaxRetries = n
+		return nil
+	}
+}
+
+// Tx is a transaction in progress, see Transaction.
+type Tx struct {
+	conn   redis.Conn
+	queued [][]interface{} // command name followed by its arguments
+}
+
+// Do runs a command at once, outside of the transaction, e.g. to read the
+// watched keys.
+func (tx *Tx) Do(cmd string, args ...interface{}) (interface{}, error) {
+	return tx.conn.Do(cmd, args...)
+}
+
+// Watch watches further keys. It must be called before their values are
+// read.
+func (tx *Tx) Watch(keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	_, err := tx.conn.Do("WATCH", keyArgs(keys[0], keys[1:])...)
+	return err
+}
+
+// Queue adds a command to the transaction. The queued commands are run
+// atomically with MULTI/EXEC once the function passed to Transaction
+// returns nil.
+func (tx *Tx) Queue(cmd string, args ...interface{}) {
+	tx.queued = append(tx.queued, append([]interface{}{cmd}, args...))
+}
+
+// Transaction runs fn and then the commands fn queued atomically, with
+// optimistic locking on the watch keys: if any of them changes before
+// the queued commands run, the transaction is discarded and fn is called
+// again, up to the retry limit of the Client, after which ErrTxConflict is
+// returned. fn should read the watched keys with tx.Do and must not have
+// other side effects. The replies of the queued commands are returned; if
+// one of them failed, its error is returned as well.
+func (c *Client) Transaction(fn func(tx *Tx) error,
+	watch ...string) ([]interface{}, error) {
+	conn := c.pool.Get()
+	defer conn.Close()
+	for i := 0; i <= c.txMaxRetries; i++ {
+		tx := &Tx{conn: conn}
+		if err := tx.Watch(watch...); err != nil {
+			return nil, err
+		}
+		if err := fn(tx); err != nil {
+			conn.Do("UNWATCH")
+			return nil, err
+		}
+		if len(tx.queued) == 0 {
+			_, err := conn.Do("UNWATCH")
+			return nil, err
+		}
+		replies, err := execQueued(conn, tx.queued)
+		if err == redis.ErrNil {
+			continue
+		}
+		return replies, err
+	}
+	return nil, ErrTxConflict
+}
+
+// execQueued runs cmds with MULTI/EXEC on conn. It returns ErrNil if the
+// transaction was aborted by a watched key.
+func execQueued(conn redis.Conn, cmds [][]interface{}) ([]interface{},
+	error) {
+	if err := conn.Send("MULTI"); err != nil {
+		return nil, err
+	}
+	for _, cmd := range cmds {
+		if err := conn.Send(cmd[0].(string), cmd[1:]...); err != nil {
+			return nil, err
+		}
+	}
+	replies, err := redis.Values(conn.Do("EXEC"))
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range replies {
+		if e, ok := r.(redis.Error); ok {
+			return replies, e
+		}
+	}
+	return replies, nil
+}
+
+// execWithExpire runs cmd on key and sets its expiration to expire
+// seconds atomically, and returns the reply of cmd.
+func execWithExpire(conn redis.Conn, expire int, cmd, key string,
+	args ...interface{}) (interface{}, error) {
+	replies, err := execQueued(conn, [][]interface{}{
+		append([]interface{}{cmd, key}, args...),
+		{"EXPIRE", key, expire},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return replies[0], nil
+}
+
+func Transaction(fn func(tx *Tx) error, watch ...string) ([]interface{}, error) {
+	return DefaultClient().Transaction(fn, watch...)
+}
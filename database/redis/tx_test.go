@@ -0,0 +1,82 @@
+package redis
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	gc "gopkg.in/check.v1"
+)
+
+func (s *clientSuite) TestAtomicExpire(c *gc.C) {
+	c.Assert(s.cli.SetValueAndExpire("k", "v", 0), gc.IsNil)
+	c.Assert(s.mr.TTL("k"), gc.Equals, 300*time.Second)
+	c.Assert(s.cli.SetSetValueBasedOnExpire("s", "a", 7), gc.IsNil)
+	c.Assert(s.mr.TTL("s"), gc.Equals, 7*time.Second)
+	c.Assert(s.cli.PushElementWithTail("l", "a", 9), gc.IsNil)
+	c.Assert(s.mr.TTL("l"), gc.Equals, 9*time.Second)
+
+	// a failing write must not leave an expiration behind
+	s.mr.Set("str", "x")
+	c.Assert(s.cli.PushElementWithTail("str", "a", 9), gc.NotNil)
+}
+
+func (s *clientSuite) TestTransaction(c *gc.C) {
+	s.mr.Set("balance", "100")
+	attempts := 0
+	replies, err := s.cli.Transaction(func(tx *Tx) error {
+		attempts++
+		balance, err := redis.Int(tx.Do("GET", "balance"))
+		if err != nil {
+			return err
+		}
+		if attempts == 1 {
+			// a concurrent writer changes the watched key
+			s.mr.Set("balance", "50")
+		}
+		tx.Queue("SET", "balance", balance-30)
+		tx.Queue("INCR", "withdrawals")
+		return nil
+	}, "balance")
+	c.Assert(err, gc.IsNil)
+	c.Assert(attempts, gc.Equals, 2)
+	c.Assert(replies, gc.HasLen, 2)
+	c.Assert(replies[1], gc.Equals, int64(1))
+	v, _ := s.mr.Get("balance")
+	c.Assert(v, gc.Equals, "20")
+}
+
+func (s *clientSuite) TestTransactionConflict(c *gc.C) {
+	cli, _ := NewClient(SetAddr(s.mr.Addr()), SetTxMaxRetries(2))
+	defer cli.Close()
+	attempts := 0
+	_, err := cli.Transaction(func(tx *Tx) error {
+		attempts++
+		s.mr.Set("k", strconv.Itoa(attempts))
+		tx.Queue("SET", "k", "mine")
+		return nil
+	}, "k")
+	c.Assert(err, gc.Equals, ErrTxConflict)
+	c.Assert(attempts, gc.Equals, 3)
+}
+
+func (s *clientSuite) TestTransactionErrors(c *gc.C) {
+	boom := errors.New("boom")
+	_, err := s.cli.Transaction(func(tx *Tx) error {
+		tx.Queue("SET", "k", "v")
+		return boom
+	}, "k")
+	c.Assert(err, gc.Equals, boom)
+	c.Assert(s.mr.Exists("k"), gc.Equals, false)
+
+	s.mr.Set("str", "x")
+	replies, err := s.cli.Transaction(func(tx *Tx) error {
+		tx.Queue("SET", "k", "v")
+		tx.Queue("INCR", "str")
+		return nil
+	})
+	c.Assert(err, gc.ErrorMatches, ".*not an integer.*")
+	c.Assert(replies, gc.HasLen, 2)
+	c.Assert(replies[0], gc.Equals, "OK")
+}
@@ -0,0 +1,211 @@
+package redis
+
+import (
+	"strconv"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// The write methods of sorted sets take an optional expiration of the
+// whole set in seconds, applied like in SetValueAndExpire: 0 means 300s.
+// Without it the expiration of the key is left unchanged.
+//
+// Score bounds are strings to allow "-inf", "+inf" and exclusive bounds
+// like "(1.5"; use strconv.FormatFloat or ScoreBound for plain numbers.
+
+// ZMember is a member of a sorted set with its score.
+type ZMember struct {
+	Member string
+	Score  float64
+}
+
+// ScoreBound formats score as an inclusive score bound.
+func ScoreBound(score float64) string {
+	return strconv.FormatFloat(score, 'g', -1, 64)
+}
+
+// ZAdd adds member with score to the sorted set key, or updates its score.
+func (c *Client) ZAdd(key string, score float64, member string,
+	expire ...int) error {
+	_, err := c.doExpire(expire, "ZADD", key, score, member)
+	return err
+}
+
+// ZAddMembers adds or updates several members of the sorted set key and
+// returns the number of new members.
+func (c *Client) ZAddMembers(key string, members []ZMember,
+	expire ...int) (int, error) {
+	if len(members) == 0 {
+		return 0, nil
+	}
+	args := make([]interface{}, 0, 2*len(members))
+	for _, m := range members {
+		args = append(args, m.Score, m.Member)
+	}
+	return redis.Int(c.doExpire(expire, "ZADD", key, args...))
+}
+
+// ZIncrBy increments the score of member by inc and returns the new score.
+func (c *Client) ZIncrBy(key string, inc float64, member string,
+	expire ...int) (float64, error) {
+	return redis.Float64(c.doExpire(expire, "ZINCRBY", key, inc, member))
+}
+
+// ZScore returns the score of member, or ErrNil if it is not in the set.
+func (c *Client) ZScore(key, member string) (float64, error) {
+	return redis.Float64(c.do("ZSCORE", key, member))
+}
+
+// ZRank returns the 0-based rank of member by ascending score, or ErrNil
+// if it is not in the set.
+func (c *Client) ZRank(key, member string) (int, error) {
+	return redis.Int(c.do("ZRANK", key, member))
+}
+
+// ZRevRank returns the 0-based rank of member by descending score, or
+// ErrNil if it is not in the set.
+func (c *Client) ZRevRank(key, member string) (int, error) {
+	return redis.Int(c.do("ZREVRANK", key, member))
+}
+
+// ZRem removes members from the sorted set key and returns how many
+// existed.
+func (c *Client) ZRem(key string, members ...string) (int, error) {
+	return redis.Int(c.do("ZREM", keyArgs(key, members)...))
+}
+
+// ZCard returns the number of members of the sorted set key.
+func (c *Client) ZCard(key string) (int, error) {
+	return redis.Int(c.do("ZCARD", key))
+}
+
+// ZCount returns the number of members with a score between min and max.
+func (c *Client) ZCount(key, min, max string) (int, error) {
+	return redis.Int(c.do("ZCOUNT", key, min, max))
+}
+
+// ZRange returns the members ranked start to stop by ascending score with
+// their scores. Negative ranks count from the end, -1 being the last.
+func (c *Client) ZRange(key string, start, stop int) ([]ZMember, error) {
+	return zMembers(c.do("ZRANGE", key, start, stop, "WITHSCORES"))
+}
+
+// ZRevRange is like ZRange by descending score, e.g. for the top of a
+// leaderboard.
+func (c *Client) ZRevRange(key string, start, stop int) ([]ZMember, error) {
+	return zMembers(c.do("ZREVRANGE", key, start, stop, "WITHSCORES"))
+}
+
+// ZRangeByScore returns the members with a score between min and max by
+// ascending score. If count is positive, at most count members are
+// returned after skipping offset.
+func (c *Client) ZRangeByScore(key, min, max string, offset,
+	count int) ([]ZMember, error) {
+	args := []interface{}{key, min, max, "WITHSCORES"}
+	if count > 0 {
+		args = append(args, "LIMIT", offset, count)
+	}
+	return zMembers(c.do("ZRANGEBYSCORE", args...))
+}
+
+// ZRevRangeByScore is like ZRangeByScore by descending score; note that
+// max comes first.
+func (c *Client) ZRevRangeByScore(key, max, min string, offset,
+	count int) ([]ZMember, error) {
+	args := []interface{}{key, max, min, "WITHSCORES"}
+	if count > 0 {
+		args = append(args, "LIMIT", offset, count)
+	}
+	return zMembers(c.do("ZREVRANGEBYSCORE", args...))
+}
+
+// ZRemRangeByScore removes the members with a score between min and max
+// and returns how many were removed.
+func (c *Client) ZRemRangeByScore(key, min, max string) (int, error) {
+	return redis.Int(c.do("ZREMRANGEBYSCORE", key, min, max))
+}
+
+// ZRemRangeByRank removes the members ranked start to stop by ascending
+// score and returns how many were removed.
+func (c *Client) ZRemRangeByRank(key string, start, stop int) (int, error) {
+	return redis.Int(c.do("ZREMRANGEBYRANK", key, start, stop))
+}
+
+// zMembers converts a WITHSCORES reply.
+func zMembers(reply interface{}, err error) ([]ZMember, error) {
+	values, err := redis.Strings(reply, err)
+	if err != nil {
+		return nil, err
+	}
+	members := make([]ZMember, 0, len(values)/2)
+	for i := 0; i+1 < len(values); i += 2 {
+		score, err := strconv.ParseFloat(values[i+1], 64)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, ZMember{Member: values[i], Score: score})
+	}
+	return members, nil
+}
+
+func ZAdd(key string, score float64, member string, expire ...int) error {
+	return DefaultClient().ZAdd(key, score, member, expire...)
+}
+
+func ZAddMembers(key string, members []ZMember, expire ...int) (int, error) {
+	return DefaultClient().ZAddMembers(key, members, expire...)
+}
+
+func ZIncrBy(key string, inc float64, member string, expire ...int) (float64,
+	error) {
+	return DefaultClient().ZIncrBy(key, inc, member, expire...)
+}
+
+func ZScore(key, member string) (float64, error) {
+	return DefaultClient().ZScore(key, member)
+}
+
+func ZRank(key, member string) (int, error) {
+	return DefaultClient().ZRank(key, member)
+}
+
+func ZRevRank(key, member string) (int, error) {
+	return DefaultClient().ZRevRank(key, member)
+}
+
+func ZRem(key string, members ...string) (int, error) {
+	return DefaultClient().ZRem(key, members...)
+}
+
+func ZCard(key string) (int, error) {
+	return DefaultClient().ZCard(key)
+}
+
+func ZCount(key, min, max string) (int, error) {
+	return DefaultClient().ZCount(key, min, max)
+}
+
+func ZRange(key string, start, stop int) ([]ZMember, error) {
+	return DefaultClient().ZRange(key, start, stop)
+}
+
+func ZRevRange(key string, start, stop int) ([]ZMember, error) {
+	return DefaultClient().ZRevRange(key, start, stop)
+}
+
+func ZRangeByScore(key, min, max string, offset, count int) ([]ZMember, error) {
+	return DefaultClient().ZRangeByScore(key, min, max, offset, count)
+}
+
+func ZRevRangeByScore(key, max, min string, offset, count int) ([]ZMember,
+	error) {
+	return DefaultClient().ZRevRangeByScore(key, max, min, offset, count)
+}
+
+func ZRemRangeByScore(key, min, max string) (int, error) {
+	return DefaultClient().ZRemRangeByScore(key, min, max)
+}
+
+func ZRemRangeByRank(key string, start, stop int) (int, error) {
+	return DefaultClient().ZRemRangeByRank(key, start, stop)
+}
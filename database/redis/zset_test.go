@@ -0,0 +1,66 @@
+package redis
+
+import (
+	"time"
+
+	gc "gopkg.in/check.v1"
+)
+
+func (s *clientSuite) TestSortedSet(c *gc.C) {
+	added, err := s.cli.ZAddMembers("board", []ZMember{
+		{"ann", 10}, {"bob", 20}, {"cid", 30}}, 60)
+	c.Assert(err, gc.IsNil)
+	c.Assert(added, gc.Equals, 3)
+	c.Assert(s.mr.TTL("board"), gc.Equals, time.Minute)
+	c.Assert(s.cli.ZAdd("board", 25, "dan"), gc.IsNil)
+
+	score, err := s.cli.ZIncrBy("board", 15, "ann")
+	c.Assert(err, gc.IsNil)
+	c.Assert(score, gc.Equals, 25.0)
+	score, err = s.cli.ZScore("board", "bob")
+	c.Assert(err, gc.IsNil)
+	c.Assert(score, gc.Equals, 20.0)
+	_, err = s.cli.ZScore("board", "nobody")
+	c.Assert(err, gc.Equals, ErrNil)
+
+	rank, err := s.cli.ZRevRank("board", "cid")
+	c.Assert(err, gc.IsNil)
+	c.Assert(rank, gc.Equals, 0)
+	rank, err = s.cli.ZRank("board", "bob")
+	c.Assert(err, gc.IsNil)
+	c.Assert(rank, gc.Equals, 0)
+	_, err = s.cli.ZRevRank("board", "nobody")
+	c.Assert(err, gc.Equals, ErrNil)
+
+	top, err := s.cli.ZRevRange("board", 0, 1)
+	c.Assert(err, gc.IsNil)
+	c.Assert(top, gc.DeepEquals, []ZMember{{"cid", 30}, {"dan", 25}})
+	low, err := s.cli.ZRange("board", 0, 0)
+	c.Assert(err, gc.IsNil)
+	c.Assert(low, gc.DeepEquals, []ZMember{{"bob", 20}})
+
+	ms, err := s.cli.ZRangeByScore("board", "(20", "+inf", 1, 1)
+	c.Assert(err, gc.IsNil)
+	c.Assert(ms, gc.HasLen, 1)
+	c.Assert(ms[0].Score, gc.Equals, 25.0)
+	ms, err = s.cli.ZRevRangeByScore("board", ScoreBound(30), "-inf", 0, 0)
+	c.Assert(err, gc.IsNil)
+	c.Assert(ms, gc.HasLen, 4)
+	c.Assert(ms[0], gc.Equals, ZMember{"cid", 30})
+	n, err := s.cli.ZCount("board", "20", "25")
+	c.Assert(err, gc.IsNil)
+	c.Assert(n, gc.Equals, 3)
+
+	n, err = s.cli.ZRem("board", "bob", "nobody")
+	c.Assert(err, gc.IsNil)
+	c.Assert(n, gc.Equals, 1)
+	n, err = s.cli.ZRemRangeByScore("board", "-inf", "(30")
+	c.Assert(err, gc.IsNil)
+	c.Assert(n, gc.Equals, 2)
+	n, err = s.cli.ZRemRangeByRank("board", 0, -1)
+	c.Assert(err, gc.IsNil)
+	c.Assert(n, gc.Equals, 1)
+	n, err = s.cli.ZCard("board")
+	c.Assert(err, gc.IsNil)
+	c.Assert(n, gc.Equals, 0)
+}
@@ -0,0 +1,281 @@
+// Copyright 2018 JXB. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package net
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/zlxtqbdgdgd/sailor/thirdparty/glog"
+)
+
+// BreakerState is the state of the circuit breaker of one host.
+type BreakerState int
+
+const (
+	// StateClosed lets all requests pass and counts failures.
+	StateClosed BreakerState = iota
+	// StateOpen rejects all requests until the cool-down has passed.
+	StateOpen
+	// StateHalfOpen lets a few probe requests pass to test the upstream.
+	StateHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half-open"
+	}
+	return fmt.Sprintf("BreakerState(%d)", int(s))
+}
+
+// Outcome is the result of a request reported to a CircuitBreaker.
+type Outcome int
+
+const (
+	// OutcomeSuccess is a request the upstream handled.
+	OutcomeSuccess Outcome = iota
+	// OutcomeFailure is a request the upstream failed.
+	OutcomeFailure
+	// OutcomeIgnored is a request that says nothing about the upstream,
+	// e.g. one cancelled by the caller. It frees its probe slot without
+	// being counted.
+	OutcomeIgnored
+)
+
+// BreakerSettings configures a CircuitBreaker.
+type BreakerSettings struct {
+	// ConsecutiveFailures opens the circuit after this many failures in a
+	// row. Zero disables the check.
+	ConsecutiveFailures int
+	// FailureRate opens the circuit when the ratio of failed requests in
+	// the current window reaches it, e.g. 0.5. Zero disables the check.
+	FailureRate float64
+	// MinRequests is the number of requests a window needs before
+	// FailureRate is evaluated.
+	MinRequests int
+	// Window is the period over which FailureRate is computed while the
+	// circuit is closed.
+	Window time.Duration
+	// CoolDown is how long the circuit stays open before probing again.
+	CoolDown time.Duration
+	// HalfOpenRequests is the number of probes that must succeed in the
+	// half-open state to close the circuit again.
+	HalfOpenRequests int
+	// OnStateChange, if set, is called whenever the circuit of a host
+	// changes its state. It must not block nor call the breaker.
+	OnStateChange func(host string, from, to BreakerState)
+}
+
+// DefaultBreakerSettings opens the circuit after 5 consecutive failures
+// or a 50% failure rate over at least 20 requests in 10s, and probes again
+// after 30s.
+var DefaultBreakerSettings = BreakerSettings{
+	ConsecutiveFailures: 5,
+	FailureRate:         0.5,
+	MinRequests:         20,
+	Window:              10 * time.Second,
+	CoolDown:            30 * time.Second,
+	HalfOpenRequests:    1,
+}
+
+// CircuitOpenError is returned without contacting the upstream while its
+// circuit is open.
+type CircuitOpenError struct {
+	Host  string    // upstream host
+	Until time.Time // when the circuit will be probed again
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("circuit breaker open for %s until %s", e.Host,
+		e.Until.Format(time.RFC3339))
+}
+
+// IsCircuitOpen reports whether err was caused by an open circuit.
+func IsCircuitOpen(err error) bool {
+	var e *CircuitOpenError
+	return errors.As(err, &e)
+}
+
+// LogBreakerStateChange is an OnStateChange callback writing state
+// changes to glog.
+func LogBreakerStateChange(host string, from, to BreakerState) {
+	if to == StateOpen {
+		glog.Warningf("circuit breaker for %s: %s -> %s", host, from, to)
+	} else {
+		glog.Infof("circuit breaker for %s: %s -> %s", host, from, to)
+	}
+}
+
+// CircuitBreaker keeps one circuit per upstream host. It is safe for
+// concurrent use.
+type CircuitBreaker struct {
+	settings BreakerSettings
+
+	mu    sync.Mutex
+	hosts map[string]*circuit
+}
+
+// circuit is the breaker state of one host.
+type circuit struct {
+	state       BreakerState
+	openedAt    time.Time
+	windowStart time.Time
+	requests    int // requests in the current window
+	failures    int // failures in the current window
+	consecutive int // consecutive failures
+	probes      int // probes in flight while half-open
+	successes   int // successful probes while half-open
+}
+
+// NewCircuitBreaker returns a CircuitBreaker with the given settings.
+func NewCircuitBreaker(settings BreakerSettings) *CircuitBreaker {
+	if settings.HalfOpenRequests <= 0 {
+		settings.HalfOpenRequests = 1
+	}
+	return &CircuitBreaker{settings: settings, hosts: make(map[string]*circuit)}
+}
+
+// State returns the current state of the circuit of host.
+func (cb *CircuitBreaker) State(host string) BreakerState {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	if ci, ok := cb.hosts[host]; ok {
+		cb.refresh(host, ci, time.Now())
+		return ci.state
+	}
+	return StateClosed
+}
+
+// Allow asks whether a request to host may be sent. If not, the error is
+// a *CircuitOpenError. Otherwise done must be called with the outcome of
+// the request once it is known.
+func (cb *CircuitBreaker) Allow(host string) (done func(Outcome), err error) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	now := time.Now()
+	ci, ok := cb.hosts[host]
+	if !ok {
+		ci = &circuit{windowStart: now}
+		cb.hosts[host] = ci
+	}
+	cb.refresh(host, ci, now)
+	switch ci.state {
+	case StateOpen:
+		return nil, &CircuitOpenError{Host: host,
+			Until: ci.openedAt.Add(cb.settings.CoolDown)}
+	case StateHalfOpen:
+		if ci.probes+ci.successes >= cb.settings.HalfOpenRequests {
+			return nil, &CircuitOpenError{Host: host, Until: now}
+		}
+		ci.probes++
+	}
+	state := ci.state
+	var once sync.Once
+	return func(outcome Outcome) {
+		once.Do(func() { cb.record(host, state, outcome) })
+	}, nil
+}
+
+// record updates the circuit of host with the outcome of a request that
+// was allowed in the given state.
+func (cb *CircuitBreaker) record(host string, allowedIn BreakerState,
+	outcome Outcome) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	now := time.Now()
+	ci := cb.hosts[host]
+	cb.refresh(host, ci, now)
+
+	if allowedIn == StateHalfOpen {
+		if ci.state != StateHalfOpen {
+			return
+		}
+		ci.probes--
+		switch outcome {
+		case OutcomeIgnored:
+			return
+		case OutcomeFailure:
+			cb.setState(host, ci, StateOpen, now)
+			return
+		}
+		ci.successes++
+		if ci.successes >= cb.settings.HalfOpenRequests {
+			cb.setState(host, ci, StateClosed, now)
+		}
+		return
+	}
+	if ci.state != StateClosed || outcome == OutcomeIgnored {
+		return
+	}
+	ci.requests++
+	if outcome == OutcomeSuccess {
+		ci.consecutive = 0
+		return
+	}
+	ci.failures++
+	ci.consecutive++
+	s := cb.settings
+	if s.ConsecutiveFailures > 0 && ci.consecutive >= s.ConsecutiveFailures {
+		cb.setState(host, ci, StateOpen, now)
+		return
+	}
+	if s.FailureRate > 0 && ci.requests >= s.MinRequests &&
+		float64(ci.failures)/float64(ci.requests) >= s.FailureRate {
+		cb.setState(host, ci, StateOpen, now)
+	}
+}
+
+// refresh moves an open circuit to half-open after the cool-down and
+// starts a new counting window when the current one is over.
+func (cb *CircuitBreaker) refresh(host string, ci *circuit, now time.Time) {
+	switch ci.state {
+	case StateOpen:
+		if now.Sub(ci.openedAt) >= cb.settings.CoolDown {
+			cb.setState(host, ci, StateHalfOpen, now)
+		}
+	case StateClosed:
+		if cb.settings.Window > 0 && now.Sub(ci.windowStart) >= cb.settings.Window {
+			ci.windowStart = now
+			ci.requests, ci.failures = 0, 0
+		}
+	}
+}
+
+func (cb *CircuitBreaker) setState(host string, ci *circuit, to BreakerState,
+	now time.Time) {
+	from := ci.state
+	if from == to {
+		return
+	}
+	ci.state = to
+	ci.probes, ci.successes = 0, 0
+	switch to {
+	case StateOpen:
+		ci.openedAt = now
+	case StateClosed:
+		ci.windowStart = now
+		ci.requests, ci.failures, ci.consecutive = 0, 0, 0
+	}
+	if cb.settings.OnStateChange != nil {
+		cb.settings.OnStateChange(host, from, to)
+	}
+}
@@ -0,0 +1,199 @@
+// Copyright 2018 JXB. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package net
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreakerStates(t *testing.T) {
+	var mu sync.Mutex
+	var changes []string
+	cb := NewCircuitBreaker(BreakerSettings{
+		ConsecutiveFailures: 3,
+		CoolDown:            50 * time.Millisecond,
+		HalfOpenRequests:    1,
+		OnStateChange: func(host string, from, to BreakerState) {
+			mu.Lock()
+			changes = append(changes, host+":"+from.String()+">"+to.String())
+			mu.Unlock()
+		},
+	})
+	for i := 0; i < 3; i++ {
+		done, err := cb.Allow("a")
+		if err != nil {
+			t.Fatalf("request %d should pass: %v", i, err)
+		}
+		done(OutcomeFailure)
+	}
+	if cb.State("a") != StateOpen || cb.State("b") != StateClosed {
+		t.Fatalf("want a open and b closed, got %v, %v", cb.State("a"),
+			cb.State("b"))
+	}
+	if _, err := cb.Allow("a"); !IsCircuitOpen(err) {
+		t.Fatalf("want CircuitOpenError, got %v", err)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	done, err := cb.Allow("a")
+	if err != nil {
+		t.Fatalf("probe should pass after cool-down: %v", err)
+	}
+	if _, err := cb.Allow("a"); !IsCircuitOpen(err) {
+		t.Fatal("only one probe is allowed while half-open")
+	}
+	done(OutcomeSuccess)
+	if cb.State("a") != StateClosed {
+		t.Fatalf("successful probe should close the circuit, got %v",
+			cb.State("a"))
+	}
+	want := "a:closed>open a:open>half-open a:half-open>closed"
+	if got := strings.Join(changes, " "); got != want {
+		t.Fatalf("state changes want %q, got %q", want, got)
+	}
+}
+
+func TestCircuitBreakerFailureRate(t *testing.T) {
+	cb := NewCircuitBreaker(BreakerSettings{
+		FailureRate: 0.5,
+		MinRequests: 4,
+		Window:      time.Minute,
+		CoolDown:    time.Minute,
+	})
+	for _, outcome := range []Outcome{OutcomeSuccess, OutcomeFailure,
+		OutcomeSuccess, OutcomeFailure} {
+		done, err := cb.Allow("h")
+		if err != nil {
+			t.Fatal(err)
+		}
+		done(outcome)
+	}
+	if cb.State("h") != StateOpen {
+		t.Fatalf("50%% failures should open the circuit, got %v", cb.State("h"))
+	}
+}
+
+func TestCircuitBreakerIgnoredOutcome(t *testing.T) {
+	cb := NewCircuitBreaker(BreakerSettings{
+		ConsecutiveFailures: 2,
+		CoolDown:            20 * time.Millisecond,
+	})
+	for _, outcome := range []Outcome{OutcomeFailure, OutcomeIgnored,
+		OutcomeSuccess, OutcomeFailure, OutcomeIgnored, OutcomeFailure} {
+		done, err := cb.Allow("h")
+		if err != nil {
+			t.Fatal(err)
+		}
+		done(outcome)
+	}
+	if cb.State("h") != StateOpen {
+		t.Fatalf("ignored outcomes must not reset the failure count, got %v",
+			cb.State("h"))
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	done, err := cb.Allow("h")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done(OutcomeIgnored)
+	if cb.State("h") != StateHalfOpen {
+		t.Fatalf("ignored probe must keep the circuit half-open, got %v",
+			cb.State("h"))
+	}
+	if done, err = cb.Allow("h"); err != nil {
+		t.Fatalf("ignored probe should free its slot: %v", err)
+	}
+	done(OutcomeSuccess)
+	if cb.State("h") != StateClosed {
+		t.Fatalf("successful probe should close the circuit, got %v",
+			cb.State("h"))
+	}
+}
+
+func TestClientCircuitBreaker(t *testing.T) {
+	var calls int32
+	ts := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			atomic.AddInt32(&calls, 1)
+			w.WriteHeader(502)
+		}))
+	defer ts.Close()
+
+	cb := NewCircuitBreaker(BreakerSettings{
+		ConsecutiveFailures: 2,
+		CoolDown:            time.Minute,
+	})
+	c, err := NewClient(SetCircuitBreaker(cb),
+		SetRetry(5, time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.Get(ts.URL)
+	if !IsCircuitOpen(err) {
+		t.Fatalf("retries should stop once the circuit opens, got %v", err)
+	}
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Fatalf("want 2 upstream calls, got %d", n)
+	}
+	if _, err = c.Get(ts.URL); !IsCircuitOpen(err) {
+		t.Fatalf("want fail fast, got %v", err)
+	}
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Fatalf("open circuit should not reach the upstream, got %d calls", n)
+	}
+}
+
+func TestClientCircuitBreakerCallerDeadline(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			time.Sleep(100 * time.Millisecond)
+		}))
+	defer ts.Close()
+
+	cb := NewCircuitBreaker(BreakerSettings{
+		ConsecutiveFailures: 1,
+		CoolDown:            time.Minute,
+	})
+	c, err := NewClient(SetCircuitBreaker(cb))
+	if err != nil {
+		t.Fatal(err)
+	}
+	host := strings.TrimPrefix(ts.URL, "http://")
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, _, _, err = c.DoRequestCtx(ctx, "GET", ts.URL, nil, nil, 1000); err == nil {
+		t.Fatal("want error after the caller deadline")
+	}
+	if cb.State(host) != StateClosed {
+		t.Fatalf("caller deadline must not count as a failure, got %v",
+			cb.State(host))
+	}
+	if _, _, _, err = c.DoRequestCtx(context.Background(), "GET", ts.URL,
+		nil, nil, 20); err == nil {
+		t.Fatal("want error after the attempt timeout")
+	}
+	if cb.State(host) != StateOpen {
+		t.Fatalf("attempt timeout should count as a failure, got %v",
+			cb.State(host))
+	}
+}
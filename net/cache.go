@@ -0,0 +1,383 @@
+// Copyright 2018 JXB. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package net
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zlxtqbdgdgd/sailor/util"
+)
+
+// CacheEntry is a cached response.
+type CacheEntry struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+	Stored     time.Time // when the response was stored or revalidated
+	Expires    time.Time // until when the response is fresh
+	// MustRevalidate forbids serving the response once it is stale.
+	MustRevalidate bool
+	// RequestHeader holds the request headers the response varies on,
+	// see varyHeaders. The entry only answers requests with the same
+	// values.
+	RequestHeader http.Header
+}
+
+// varyHeaders returns the request headers a response depends on: those
+// named by its Vary header, and always Accept-Encoding, since a body the
+// client asked to be compressed is cached as received.
+func varyHeaders(header http.Header) []string {
+	names := []string{"Accept-Encoding"}
+	for _, v := range header.Values("Vary") {
+		for _, name := range strings.Split(v, ",") {
+			if name = http.CanonicalHeaderKey(strings.TrimSpace(name)); name != "" &&
+				name != "Accept-Encoding" {
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+// matches reports whether e may answer req.
+func (e *CacheEntry) matches(req *http.Request) bool {
+	for _, name := range varyHeaders(e.Header) {
+		if req.Header.Get(name) != e.RequestHeader.Get(name) {
+			return false
+		}
+	}
+	return true
+}
+
+func (e *CacheEntry) size() int64 {
+	n := int64(len(e.Body))
+	for _, h := range []http.Header{e.Header, e.RequestHeader} {
+		for k, vs := range h {
+			for _, v := range vs {
+				n += int64(len(k) + len(v))
+			}
+		}
+	}
+	return n
+}
+
+// CacheStore stores cached responses by key. Implementations must be safe
+// for concurrent use; entries passed in and returned must not be modified.
+type CacheStore interface {
+	Get(key string) (*CacheEntry, bool)
+	Set(key string, e *CacheEntry)
+	Delete(key string)
+}
+
+// SetCache enables caching of GET responses in store. Responses are cached
+// and revalidated according to their Cache-Control, Expires, ETag and
+// Last-Modified headers. Requests setting their own If-None-Match or
+// If-Modified-Since header bypass the cache.
+func SetCache(store CacheStore) ClientOptionFunc {
+	return func(c *Client) error {
+		if c.cache == nil {
+			c.cache = &httpCache{}
+		}
+		c.cache.store = store
+		return nil
+	}
+}
+
+// SetCacheStaleIfError makes the cache serve a stale response for up to
+// maxStale after it expired when the upstream fails with an error or a 5xx
+// status, unless the response demanded revalidation.
+func SetCacheStaleIfError(maxStale time.Duration) ClientOptionFunc {
+	return func(c *Client) error {
+		if c.cache == nil {
+			c.cache = &httpCache{}
+		}
+		c.cache.staleIfError = maxStale
+		return nil
+	}
+}
+
+// httpCache implements the caching logic on top of a CacheStore.
+type httpCache struct {
+	store        CacheStore
+	staleIfError time.Duration
+}
+
+// lookup returns the entry cached for req, if any, and whether it is
+// fresh. A stale entry with validators turns req into a conditional
+// request.
+func (hc *httpCache) lookup(req *http.Request) (*CacheEntry, bool) {
+	if hc == nil || hc.store == nil || req.Method != "GET" ||
+		req.Header.Get("If-None-Match") != "" ||
+		req.Header.Get("If-Modified-Since") != "" {
+		return nil, false
+	}
+	e, ok := hc.store.Get(req.URL.String())
+	if !ok || !e.matches(req) {
+		return nil, false
+	}
+	if time.Now().Before(e.Expires) {
+		return e, true
+	}
+	if etag := e.Header.Get("Etag"); etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	if lm := e.Header.Get("Last-Modified"); lm != "" {
+		req.Header.Set("If-Modified-Since", lm)
+	}
+	return e, false
+}
+
+// stale reports whether the stale entry e may be served because the
+// upstream failed.
+func (hc *httpCache) stale(e *CacheEntry) bool {
+	return e != nil && hc.staleIfError > 0 && !e.MustRevalidate &&
+		time.Now().Before(e.Expires.Add(hc.staleIfError))
+}
+
+// update stores the response to req and returns the response to hand to
+// the caller: the cached one if the upstream answered 304 Not Modified.
+func (hc *httpCache) update(req *http.Request, cached *CacheEntry,
+	statusCode int, header http.Header, body []byte) (int, http.Header, []byte) {
+	if hc == nil || hc.store == nil || req.Method != "GET" {
+		return statusCode, header, body
+	}
+	key := req.URL.String()
+	if statusCode == http.StatusNotModified && cached != nil {
+		merged := cached.Header.Clone()
+		for k, vs := range header {
+			merged[k] = vs
+		}
+		e := newCacheEntry(req, cached.StatusCode, merged, cached.Body)
+		if e != nil {
+			hc.store.Set(key, e)
+		} else {
+			hc.store.Delete(key)
+		}
+		return cached.StatusCode, merged, cached.Body
+	}
+	if statusCode == http.StatusOK {
+		if e := newCacheEntry(req, statusCode, header, body); e != nil {
+			hc.store.Set(key, e)
+		} else {
+			hc.store.Delete(key)
+		}
+	}
+	return statusCode, header, body
+}
+
+// newCacheEntry returns the cache entry of the response to req, or nil
+// if the response must not be cached or is useless to cache. Responses
+// to requests with credentials are only cached if they are public, as
+// the cache is shared by all callers of the client.
+func newCacheEntry(req *http.Request, statusCode int, header http.Header,
+	body []byte) *CacheEntry {
+	cc := parseCacheControl(header.Get("Cache-Control"))
+	if _, ok := cc["no-store"]; ok || header.Get("Vary") == "*" {
+		return nil
+	}
+	if _, public := cc["public"]; !public &&
+		req.Header.Get("Authorization") != "" {
+		return nil
+	}
+	now := time.Now()
+	e := &CacheEntry{
+		StatusCode:    statusCode,
+		Header:        header,
+		Body:          body,
+		Stored:        now,
+		Expires:       now,
+		RequestHeader: make(http.Header),
+	}
+	for _, name := range varyHeaders(header) {
+		if vs := req.Header.Values(name); len(vs) > 0 {
+			e.RequestHeader[name] = append([]string(nil), vs...)
+		}
+	}
+	_, e.MustRevalidate = cc["must-revalidate"]
+	_, noCache := cc["no-cache"]
+	switch {
+	case noCache:
+	case cc["max-age"] != "":
+		if secs, err := strconv.Atoi(cc["max-age"]); err == nil {
+			age, _ := strconv.Atoi(header.Get("Age"))
+			e.Expires = now.Add(time.Duration(secs-age) * time.Second)
+		}
+	case header.Get("Expires") != "":
+		// an invalid Expires means already expired
+		if exp, err := http.ParseTime(header.Get("Expires")); err == nil {
+			date, err := http.ParseTime(header.Get("Date"))
+			if err != nil {
+				date = now
+			}
+			e.Expires = now.Add(exp.Sub(date))
+		}
+	}
+	if !e.Expires.After(now) && header.Get("Etag") == "" &&
+		header.Get("Last-Modified") == "" {
+		return nil
+	}
+	return e
+}
+
+// parseCacheControl parses the directives of a Cache-Control header.
+func parseCacheControl(s string) map[string]string {
+	cc := make(map[string]string)
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		if i := strings.Index(part, "="); i >= 0 {
+			cc[strings.ToLower(part[:i])] = strings.Trim(part[i+1:], `"`)
+		} else {
+			cc[strings.ToLower(part)] = ""
+		}
+	}
+	return cc
+}
+
+// MemoryCache is an in-memory LRU CacheStore limited in the number of
+// entries and in the size of the bodies and headers.
+type MemoryCache struct {
+	maxEntries int
+	maxBytes   int64
+
+	mu    sync.Mutex
+	bytes int64
+	ll    *list.List // front is the most recently used
+	items map[string]*list.Element
+}
+
+type memoryItem struct {
+	key   string
+	entry *CacheEntry
+}
+
+// NewMemoryCache returns a MemoryCache holding at most maxEntries entries
+// of maxBytes total. Zero means no limit.
+func NewMemoryCache(maxEntries int, maxBytes int64) *MemoryCache {
+	return &MemoryCache{
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+		ll:         list.New(),
+		items:      make(map[string]*list.Element),
+	}
+}
+
+func (mc *MemoryCache) Get(key string) (*CacheEntry, bool) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	el, ok := mc.items[key]
+	if !ok {
+		return nil, false
+	}
+	mc.ll.MoveToFront(el)
+	return el.Value.(*memoryItem).entry, true
+}
+
+func (mc *MemoryCache) Set(key string, e *CacheEntry) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	if el, ok := mc.items[key]; ok {
+		mc.remove(el)
+	}
+	size := e.size()
+	if mc.maxBytes > 0 && size > mc.maxBytes {
+		return
+	}
+	mc.items[key] = mc.ll.PushFront(&memoryItem{key, e})
+	mc.bytes += size
+	for (mc.maxEntries > 0 && mc.ll.Len() > mc.maxEntries) ||
+		(mc.maxBytes > 0 && mc.bytes > mc.maxBytes) {
+		mc.remove(mc.ll.Back())
+	}
+}
+
+func (mc *MemoryCache) Delete(key string) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	if el, ok := mc.items[key]; ok {
+		mc.remove(el)
+	}
+}
+
+// Len returns the number of cached entries.
+func (mc *MemoryCache) Len() int {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	return mc.ll.Len()
+}
+
+// remove removes el; the caller must hold the lock.
+func (mc *MemoryCache) remove(el *list.Element) {
+	item := mc.ll.Remove(el).(*memoryItem)
+	delete(mc.items, item.key)
+	mc.bytes -= item.entry.size()
+}
+
+// DiskCache is a CacheStore keeping one JSON file per entry in a
+// directory, so that the cache survives restarts. It has no size limit.
+type DiskCache struct {
+	dir string
+}
+
+// NewDiskCache returns a DiskCache storing its files in dir, which is
+// created if needed.
+func NewDiskCache(dir string) (*DiskCache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &DiskCache{dir: dir}, nil
+}
+
+func (dc *DiskCache) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(dc.dir, hex.EncodeToString(sum[:]))
+}
+
+func (dc *DiskCache) Get(key string) (*CacheEntry, bool) {
+	raw, err := ioutil.ReadFile(dc.path(key))
+	if err != nil {
+		return nil, false
+	}
+	e := new(CacheEntry)
+	if err := json.Unmarshal(raw, e); err != nil {
+		return nil, false
+	}
+	return e, true
+}
+
+func (dc *DiskCache) Set(key string, e *CacheEntry) {
+	raw, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	util.AtomicWriteFile(dc.path(key), raw, 0644)
+}
+
+func (dc *DiskCache) Delete(key string) {
+	os.Remove(dc.path(key))
+}
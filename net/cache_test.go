@@ -0,0 +1,266 @@
+// Copyright 2018 JXB. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package net
+
+import (
+	"compress/gzip"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCacheMaxAge(t *testing.T) {
+	var calls int32
+	ts := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			atomic.AddInt32(&calls, 1)
+			w.Header().Set("Cache-Control", "max-age=60")
+			w.Write([]byte("config"))
+		}))
+	defer ts.Close()
+
+	c, err := NewClient(SetCache(NewMemoryCache(10, 0)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		body, err := c.Get(ts.URL)
+		if err != nil || string(body) != "config" {
+			t.Fatalf("want config, got %q, %v", body, err)
+		}
+		body[0] = 'X' // must not change the cached copy
+	}
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Fatalf("want 1 upstream call, got %d", n)
+	}
+}
+
+func TestCacheVary(t *testing.T) {
+	var calls int32
+	ts := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			atomic.AddInt32(&calls, 1)
+			w.Header().Set("Cache-Control", "max-age=60")
+			w.Header().Set("Vary", "X-Lang")
+			body := `{"lang":"` + req.Header.Get("X-Lang") + `"}`
+			if strings.Contains(req.Header.Get("Accept-Encoding"), "gzip") {
+				w.Header().Set("Content-Encoding", "gzip")
+				zw := gzip.NewWriter(w)
+				zw.Write([]byte(body))
+				zw.Close()
+				return
+			}
+			w.Write([]byte(body))
+		}))
+	defer ts.Close()
+
+	c, _ := NewClient(SetCache(NewMemoryCache(0, 0)))
+	var v map[string]string
+	if err := c.GetJSON(context.Background(), ts.URL, &v); err != nil {
+		t.Fatal(err)
+	}
+	// a plain request must not get the compressed body cached for GetJSON
+	body, err := c.Get(ts.URL)
+	if err != nil || string(body) != `{"lang":""}` {
+		t.Fatalf("want plain body, got %q, %v", body, err)
+	}
+	for _, lang := range []string{"de", "fr", "de"} {
+		body, err = c.GetWithHeaderCtx(context.Background(), ts.URL,
+			map[string]string{"X-Lang": lang})
+		if err != nil || string(body) != `{"lang":"`+lang+`"}` {
+			t.Fatalf("want %s body, got %q, %v", lang, body, err)
+		}
+	}
+	if n := atomic.LoadInt32(&calls); n != 5 {
+		t.Fatalf("want 5 upstream calls, got %d", n)
+	}
+	c.GetWithHeaderCtx(context.Background(), ts.URL,
+		map[string]string{"X-Lang": "de"})
+	if atomic.LoadInt32(&calls) != 5 {
+		t.Fatal("matching request should be served from the cache")
+	}
+}
+
+func TestCacheAuthorization(t *testing.T) {
+	var calls int32
+	public := false
+	ts := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			atomic.AddInt32(&calls, 1)
+			if public {
+				w.Header().Set("Cache-Control", "public, max-age=60")
+			} else {
+				w.Header().Set("Cache-Control", "max-age=60")
+			}
+			w.Write([]byte(req.Header.Get("Authorization")))
+		}))
+	defer ts.Close()
+
+	c, _ := NewClient(SetCache(NewMemoryCache(0, 0)))
+	auth := map[string]string{"Authorization": "Bearer alice"}
+	body, _ := c.GetWithHeaderCtx(context.Background(), ts.URL, auth)
+	if string(body) != "Bearer alice" {
+		t.Fatalf("unexpected body %q", body)
+	}
+	if body, _ = c.Get(ts.URL); string(body) != "" {
+		t.Fatalf("private response leaked to another caller: %q", body)
+	}
+	public = true
+	c.GetWithHeaderCtx(context.Background(), ts.URL+"/public", auth)
+	c.GetWithHeaderCtx(context.Background(), ts.URL+"/public", auth)
+	if n := atomic.LoadInt32(&calls); n != 3 {
+		t.Fatalf("public response should be cached, got %d calls", n)
+	}
+}
+
+func TestCacheRevalidate(t *testing.T) {
+	var calls, notModified int32
+	ts := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			atomic.AddInt32(&calls, 1)
+			w.Header().Set("Cache-Control", "no-cache")
+			w.Header().Set("ETag", `"v1"`)
+			if req.Header.Get("If-None-Match") == `"v1"` {
+				atomic.AddInt32(&notModified, 1)
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			w.Write([]byte("dict"))
+		}))
+	defer ts.Close()
+
+	c, _ := NewClient(SetCache(NewMemoryCache(0, 0)))
+	for i := 0; i < 3; i++ {
+		body, err := c.Get(ts.URL)
+		if err != nil || string(body) != "dict" {
+			t.Fatalf("want dict, got %q, %v", body, err)
+		}
+	}
+	if calls != 3 || notModified != 2 {
+		t.Fatalf("want 3 calls and 2 revalidations, got %d, %d", calls,
+			notModified)
+	}
+}
+
+func TestCacheStaleIfError(t *testing.T) {
+	var fail int32
+	ts := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			if atomic.LoadInt32(&fail) == 1 {
+				w.WriteHeader(503)
+				return
+			}
+			w.Header().Set("Expires", time.Now().UTC().
+				Format(http.TimeFormat))
+			w.Header().Set("Last-Modified", "Mon, 02 Jan 2006 15:04:05 GMT")
+			w.Write([]byte("old"))
+		}))
+	defer ts.Close()
+
+	c, _ := NewClient(SetCache(NewMemoryCache(0, 0)),
+		SetCacheStaleIfError(time.Minute))
+	if _, err := c.Get(ts.URL); err != nil {
+		t.Fatal(err)
+	}
+	atomic.StoreInt32(&fail, 1)
+	body, err := c.Get(ts.URL)
+	if err != nil || string(body) != "old" {
+		t.Fatalf("want stale body, got %q, %v", body, err)
+	}
+
+	// without stale-if-error the upstream error is returned
+	c, _ = NewClient(SetCache(NewMemoryCache(0, 0)))
+	atomic.StoreInt32(&fail, 0)
+	c.Get(ts.URL)
+	atomic.StoreInt32(&fail, 1)
+	if _, err := c.Get(ts.URL); !IsStatus(err, 503) {
+		t.Fatalf("want 503, got %v", err)
+	}
+}
+
+func TestCacheNoStore(t *testing.T) {
+	var calls int32
+	ts := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			atomic.AddInt32(&calls, 1)
+			w.Header().Set("Cache-Control", "no-store, max-age=60")
+			w.Write([]byte("secret"))
+		}))
+	defer ts.Close()
+
+	mc := NewMemoryCache(0, 0)
+	c, _ := NewClient(SetCache(mc))
+	c.Get(ts.URL)
+	c.Get(ts.URL)
+	if calls != 2 || mc.Len() != 0 {
+		t.Fatalf("no-store response cached: %d calls, %d entries", calls,
+			mc.Len())
+	}
+}
+
+func TestMemoryCacheLRU(t *testing.T) {
+	mc := NewMemoryCache(2, 10)
+	entry := func(body string) *CacheEntry {
+		return &CacheEntry{Body: []byte(body)}
+	}
+	mc.Set("a", entry("aaa"))
+	mc.Set("b", entry("bbb"))
+	mc.Get("a")
+	mc.Set("c", entry("ccc")) // evicts b, the least recently used
+	if _, ok := mc.Get("b"); ok {
+		t.Fatal("b should have been evicted")
+	}
+	if _, ok := mc.Get("a"); !ok {
+		t.Fatal("a should be cached")
+	}
+	mc.Set("d", entry("dddddddd")) // 3+8 bytes exceed the limit
+	if mc.Len() != 1 {
+		t.Fatalf("want 1 entry, got %d", mc.Len())
+	}
+	mc.Set("e", entry("too large entry"))
+	if _, ok := mc.Get("e"); ok {
+		t.Fatal("entry larger than the cache should not be stored")
+	}
+}
+
+func TestDiskCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "httpcache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dc, err := NewDiskCache(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := &CacheEntry{StatusCode: 200, Header: http.Header{"Etag": {"x"}},
+		Body: []byte("body"), Expires: time.Now().Add(time.Minute)}
+	dc.Set("http://h/a", e)
+	got, ok := dc.Get("http://h/a")
+	if !ok || string(got.Body) != "body" || got.Header.Get("Etag") != "x" ||
+		!got.Expires.Equal(e.Expires) {
+		t.Fatalf("unexpected entry %+v", got)
+	}
+	dc.Delete("http://h/a")
+	if _, ok := dc.Get("http://h/a"); ok {
+		t.Fatal("entry not deleted")
+	}
+}
@@ -0,0 +1,301 @@
+// Copyright 2018 JXB. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package net
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/zlxtqbdgdgd/sailor/util"
+)
+
+// RecorderMode tells a Recorder whether to record or replay interactions.
+type RecorderMode int
+
+const (
+	// ModeReplay answers requests from the cassette only.
+	ModeReplay RecorderMode = iota
+	// ModeRecord sends requests upstream and records them, replacing the
+	// cassette on Save.
+	ModeRecord
+	// ModeAuto replays if the cassette file exists and records otherwise.
+	ModeAuto
+)
+
+// Cassette is the list of recorded interactions, stored as JSON.
+type Cassette struct {
+	Interactions []*Interaction `json:"interactions"`
+}
+
+// Interaction is a recorded request and its response.
+type Interaction struct {
+	Request  CassetteRequest  `json:"request"`
+	Response CassetteResponse `json:"response"`
+}
+
+// CassetteRequest is a recorded request.
+type CassetteRequest struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Header http.Header `json:"header,omitempty"`
+	CassetteBody
+}
+
+// CassetteResponse is a recorded response.
+type CassetteResponse struct {
+	StatusCode int         `json:"status_code"`
+	Header     http.Header `json:"header,omitempty"`
+	CassetteBody
+}
+
+// CassetteBody is a recorded body. Bodies that are not valid UTF-8 are
+// stored in base64.
+type CassetteBody struct {
+	Body     string `json:"body,omitempty"`
+	Encoding string `json:"body_encoding,omitempty"` // "" or "base64"
+}
+
+func newCassetteBody(b []byte) CassetteBody {
+	if utf8.Valid(b) {
+		return CassetteBody{Body: string(b)}
+	}
+	return CassetteBody{Body: base64.StdEncoding.EncodeToString(b),
+		Encoding: "base64"}
+}
+
+// Bytes returns the decoded body.
+func (b CassetteBody) Bytes() []byte {
+	if b.Encoding == "base64" {
+		raw, _ := base64.StdEncoding.DecodeString(b.Body)
+		return raw
+	}
+	return []byte(b.Body)
+}
+
+// MatcherFunc reports whether a request with the given body matches a
+// recorded request.
+type MatcherFunc func(req *http.Request, body []byte, rec *CassetteRequest) bool
+
+// DefaultMatcher matches requests on method, URL and body.
+func DefaultMatcher(req *http.Request, body []byte, rec *CassetteRequest) bool {
+	return req.Method == rec.Method && req.URL.String() == rec.URL &&
+		bytes.Equal(body, rec.Bytes())
+}
+
+// CassetteMissError is returned in replay mode for a request without a
+// matching interaction left in the cassette.
+type CassetteMissError struct {
+	Method string
+	URL    string
+}
+
+func (e *CassetteMissError) Error() string {
+	return fmt.Sprintf("no recorded interaction for %s %s", e.Method, e.URL)
+}
+
+// Recorder is an http.RoundTripper recording interactions to a cassette
+// file and replaying them, for deterministic tests of code using the net
+// package without live upstreams. Plug it into a Client with
+// SetMiddleware(r.Middleware()), or use it as the Transport of an
+// http.Client. A recorded interaction is replayed once; identical requests
+// get the recorded responses in order.
+type Recorder struct {
+	path   string
+	mode   RecorderMode
+	next   http.RoundTripper
+	redact map[string]bool
+	match  MatcherFunc
+
+	mu       sync.Mutex
+	cassette *Cassette
+	used     []bool
+}
+
+// NewRecorder returns a Recorder using the cassette file at path. In
+// replay mode the file must exist.
+func NewRecorder(path string, mode RecorderMode) (*Recorder, error) {
+	r := &Recorder{
+		path:     path,
+		mode:     mode,
+		next:     http.DefaultTransport,
+		match:    DefaultMatcher,
+		cassette: &Cassette{},
+	}
+	r.SetRedactHeaders(DefaultRedactHeaders...)
+	if mode == ModeAuto {
+		r.mode = ModeRecord
+		if util.CheckFileIsExist(path) {
+			r.mode = ModeReplay
+		}
+	}
+	if r.mode == ModeReplay {
+		raw, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(raw, r.cassette); err != nil {
+			return nil, fmt.Errorf("cassette %s: %w", path, err)
+		}
+		r.used = make([]bool, len(r.cassette.Interactions))
+	}
+	return r, nil
+}
+
+// Mode returns the mode of the Recorder, never ModeAuto.
+func (r *Recorder) Mode() RecorderMode {
+	return r.mode
+}
+
+// SetRedactHeaders replaces the headers whose values are not written to
+// the cassette.
+func (r *Recorder) SetRedactHeaders(headers ...string) *Recorder {
+	r.redact = redactSet(headers)
+	return r
+}
+
+// SetMatcher replaces the DefaultMatcher.
+func (r *Recorder) SetMatcher(match MatcherFunc) *Recorder {
+	r.match = match
+	return r
+}
+
+// SetTransport sets the transport used to record, by default
+// http.DefaultTransport.
+func (r *Recorder) SetTransport(rt http.RoundTripper) *Recorder {
+	r.next = rt
+	return r
+}
+
+// Middleware returns a Middleware recording through, or replaying instead
+// of, the transport of the Client.
+func (r *Recorder) Middleware() Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			return r.roundTrip(req, next)
+		})
+	}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	return r.roundTrip(req, r.next)
+}
+
+func (r *Recorder) roundTrip(req *http.Request, next http.RoundTripper) (
+	*http.Response, error) {
+	var body []byte
+	if req.Body != nil && req.Body != http.NoBody {
+		var err error
+		body, err = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req = req.Clone(req.Context())
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	if r.mode == ModeReplay {
+		return r.replay(req, body)
+	}
+
+	resp, err := next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	respBody, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(respBody))
+	r.mu.Lock()
+	r.cassette.Interactions = append(r.cassette.Interactions, &Interaction{
+		Request: CassetteRequest{
+			Method:       req.Method,
+			URL:          req.URL.String(),
+			Header:       r.redacted(req.Header),
+			CassetteBody: newCassetteBody(body),
+		},
+		Response: CassetteResponse{
+			StatusCode:   resp.StatusCode,
+			Header:       r.redacted(resp.Header),
+			CassetteBody: newCassetteBody(respBody),
+		},
+	})
+	r.mu.Unlock()
+	return resp, nil
+}
+
+func (r *Recorder) replay(req *http.Request, body []byte) (*http.Response,
+	error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, in := range r.cassette.Interactions {
+		if r.used[i] || !r.match(req, body, &in.Request) {
+			continue
+		}
+		r.used[i] = true
+		respBody := in.Response.Bytes()
+		return &http.Response{
+			Status: fmt.Sprintf("%d %s", in.Response.StatusCode,
+				http.StatusText(in.Response.StatusCode)),
+			StatusCode:    in.Response.StatusCode,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        in.Response.Header.Clone(),
+			Body:          ioutil.NopCloser(bytes.NewReader(respBody)),
+			ContentLength: int64(len(respBody)),
+			Request:       req,
+		}, nil
+	}
+	return nil, &CassetteMissError{Method: req.Method, URL: req.URL.String()}
+}
+
+// redacted returns a copy of h with the redacted headers masked.
+func (r *Recorder) redacted(h http.Header) http.Header {
+	if len(h) == 0 {
+		return nil
+	}
+	return redactHeader(h, r.redact)
+}
+
+// Save writes the recorded interactions to the cassette file. It does
+// nothing in replay mode.
+func (r *Recorder) Save() error {
+	if r.mode == ModeReplay {
+		return nil
+	}
+	r.mu.Lock()
+	raw, err := json.MarshalIndent(r.cassette, "", "  ")
+	r.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return util.AtomicWriteFile(r.path, append(raw, '\n'), 0644)
+}
+
+// Interactions returns the number of interactions in the cassette.
+func (r *Recorder) Interactions() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.cassette.Interactions)
+}
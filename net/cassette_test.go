@@ -0,0 +1,107 @@
+// Copyright 2018 JXB. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package net
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRecorder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cassette")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "upstream.json")
+
+	n := 0
+	ts := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			n++
+			raw, _ := ioutil.ReadAll(req.Body)
+			w.Header().Set("Set-Cookie", "session=secret")
+			w.Write([]byte(strings.ToUpper(string(raw)) + strings.Repeat("!", n)))
+		}))
+
+	rec, err := NewRecorder(path, ModeAuto)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Mode() != ModeRecord {
+		t.Fatal("want record mode without cassette")
+	}
+	c, _ := NewClient(SetMiddleware(rec.Middleware()),
+		SetHeader("Authorization", "Bearer secret"))
+	for _, data := range []string{"a", "a", "b"} {
+		if _, err := c.Post(ts.URL+"/q", []byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := rec.Save(); err != nil {
+		t.Fatal(err)
+	}
+	ts.Close()
+
+	raw, _ := ioutil.ReadFile(path)
+	if strings.Contains(string(raw), "secret") {
+		t.Fatalf("cassette leaks secrets:\n%s", raw)
+	}
+
+	// replay without the upstream
+	rec, err = NewRecorder(path, ModeAuto)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Mode() != ModeReplay || rec.Interactions() != 3 {
+		t.Fatalf("want replay of 3 interactions, got %d", rec.Interactions())
+	}
+	c, _ = NewClient(SetMiddleware(rec.Middleware()))
+	for _, tc := range []struct{ data, want string }{
+		{"b", "B!!!"}, {"a", "A!"}, {"a", "A!!"},
+	} {
+		body, err := c.Post(ts.URL+"/q", []byte(tc.data))
+		if err != nil || string(body) != tc.want {
+			t.Fatalf("want %q, got %q, %v", tc.want, body, err)
+		}
+	}
+	_, err = c.Post(ts.URL+"/q", []byte("a"))
+	var miss *CassetteMissError
+	if !errors.As(err, &miss) || miss.Method != "POST" {
+		t.Fatalf("want cassette miss, got %v", err)
+	}
+}
+
+func TestCassetteBinaryBody(t *testing.T) {
+	b := newCassetteBody([]byte{0xff, 0x00, 0xfe})
+	if b.Encoding != "base64" || string(b.Bytes()) != "\xff\x00\xfe" {
+		t.Fatalf("binary body not preserved: %+v", b)
+	}
+	if b := newCassetteBody([]byte("text")); b.Encoding != "" || b.Body != "text" {
+		t.Fatalf("text body should be stored as is: %+v", b)
+	}
+}
+
+func TestRecorderReplayMissingCassette(t *testing.T) {
+	if _, err := NewRecorder("/nonexistent/cassette.json", ModeReplay); err == nil {
+		t.Fatal("want error for missing cassette")
+	}
+}
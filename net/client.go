@@ -0,0 +1,765 @@
+// Copyright 2018 JXB. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package net
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultTimeout is the per-attempt timeout used when a call does not
+	// specify one.
+	DefaultTimeout = 1000 * time.Millisecond
+
+	// DefaultDialTimeout is the connect timeout of the default transport.
+	DefaultDialTimeout = 10 * time.Second
+
+	// DefaultKeepAlive is the TCP keep-alive period of the default transport.
+	DefaultKeepAlive = 90 * time.Minute
+
+	// DefaultMaxIdleConns is the maximum number of idle connections kept
+	// across all hosts by the default transport.
+	DefaultMaxIdleConns = 200
+
+	// DefaultMaxIdleConnsPerHost is the maximum number of idle connections
+	// kept per host by the default transport.
+	DefaultMaxIdleConnsPerHost = 10
+
+	// DefaultIdleConnTimeout is how long an idle connection stays in the
+	// pool of the default transport.
+	DefaultIdleConnTimeout = 90 * time.Second
+
+	// DefaultResponseHeaderTimeout is how long the default transport waits
+	// for the response headers after the request was written.
+	DefaultResponseHeaderTimeout = 10 * time.Second
+
+	// DefaultClientTimeout is the overall timeout of the underlying
+	// http.Client, including redirects and reading the body.
+	DefaultClientTimeout = 10 * time.Second
+)
+
+// Client is an HTTP client for talking to one kind of upstream. Each Client
+// owns its own connection pool, so services calling several upstreams with
+// different needs should create one Client per upstream with NewClient.
+//
+// A Client is safe for concurrent use by multiple goroutines.
+type Client struct {
+	c *http.Client // underlying HTTP client
+
+	dialer    *net.Dialer     // dialer of the transport built by NewClient
+	transport *http.Transport // transport built by NewClient, nil if c is user supplied
+
+	baseURL       string            // prefix for relative request URLs
+	header        map[string]string // headers sent with every request
+	timeout       time.Duration     // per-attempt timeout
+	retryPolicy   RetryPolicy       // decides whether failed requests are retried
+	successStatus map[int]bool      // response status codes treated as success
+	breaker       *CircuitBreaker   // optional circuit breaker per host
+	middlewares   []Middleware      // wrappers around the transport
+	limiter       *Limiter          // optional rate and concurrency limits
+	stats         *statsRecorder    // request statistics per host
+	cache         *httpCache        // optional cache of GET responses
+}
+
+// ClientOptionFunc is a function that configures a Client.
+// It is used in NewClient.
+type ClientOptionFunc func(*Client) error
+
+// NewClient creates a new Client. Without options it behaves like the
+// package level functions: a 1s per-attempt timeout, no retries and a
+// transport keeping up to 200 idle connections.
+func NewClient(options ...ClientOptionFunc) (*Client, error) {
+	c := &Client{
+		dialer: &net.Dialer{
+			Timeout:   DefaultDialTimeout,
+			KeepAlive: DefaultKeepAlive, // default value may be 7200s
+			DualStack: true,
+		},
+		transport: &http.Transport{
+			MaxIdleConns:          DefaultMaxIdleConns,
+			MaxIdleConnsPerHost:   DefaultMaxIdleConnsPerHost,
+			IdleConnTimeout:       DefaultIdleConnTimeout,
+			ExpectContinueTimeout: 10 * time.Second,
+			ResponseHeaderTimeout: DefaultResponseHeaderTimeout,
+		},
+		header:        make(map[string]string),
+		timeout:       DefaultTimeout,
+		retryPolicy:   NoRetry,
+		successStatus: map[int]bool{200: true, 201: true},
+		stats:         newStatsRecorder(),
+	}
+	c.c = &http.Client{Timeout: DefaultClientTimeout}
+
+	for _, option := range options {
+		if err := option(c); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.transport != nil {
+		c.transport.DialContext = c.dialer.DialContext
+		c.c.Transport = c.transport
+	}
+	if len(c.middlewares) > 0 {
+		// leave a user supplied http.Client untouched
+		hc := *c.c
+		hc.Transport = chain(hc.Transport, c.middlewares)
+		c.c = &hc
+	}
+	return c, nil
+}
+
+// SetHTTPClient makes the Client use the given http.Client for all
+// requests. The transport tuning options have no effect afterwards.
+func SetHTTPClient(httpClient *http.Client) ClientOptionFunc {
+	return func(c *Client) error {
+		if httpClient == nil {
+			return errors.New("net: http client must not be nil")
+		}
+		c.c = httpClient
+		c.transport = nil
+		return nil
+	}
+}
+
+// SetBaseURL sets the prefix that is prepended to request URLs which are
+// not absolute, e.g. "http://10.0.0.1:8080/api".
+func SetBaseURL(baseURL string) ClientOptionFunc {
+	return func(c *Client) error {
+		c.baseURL = strings.TrimRight(baseURL, "/")
+		return nil
+	}
+}
+
+// SetHeader adds a header that is sent with every request. Headers passed
+// to a single call take precedence.
+func SetHeader(key, value string) ClientOptionFunc {
+	return func(c *Client) error {
+		c.header[key] = value
+		return nil
+	}
+}
+
+// SetHeaders adds the given headers to the ones sent with every request.
+func SetHeaders(header map[string]string) ClientOptionFunc {
+	return func(c *Client) error {
+		for k, v := range header {
+			c.header[k] = v
+		}
+		return nil
+	}
+}
+
+// SetTimeout sets the default per-attempt timeout (1s by default).
+func SetTimeout(timeout time.Duration) ClientOptionFunc {
+	return func(c *Client) error {
+		if timeout <= 0 {
+			return errors.New("net: timeout must be positive")
+		}
+		c.timeout = timeout
+		return nil
+	}
+}
+
+// SetClientTimeout sets the overall timeout of the underlying http.Client
+// (10s by default). It caps every attempt regardless of SetTimeout.
+func SetClientTimeout(timeout time.Duration) ClientOptionFunc {
+	return func(c *Client) error {
+		c.c.Timeout = timeout
+		return nil
+	}
+}
+
+// SetRetry sets how many times a failed request is retried and how long
+// to sleep between two attempts. No retries are done by default.
+func SetRetry(retryTimes int, interval time.Duration) ClientOptionFunc {
+	return func(c *Client) error {
+		if retryTimes < 0 {
+			return errors.New("net: retry times must not be negative")
+		}
+		c.retryPolicy = NewRetryPolicy(retryTimes, NewConstantBackoff(interval))
+		return nil
+	}
+}
+
+// SetRetryPolicy sets the policy deciding whether and when failed
+// requests are retried.
+func SetRetryPolicy(policy RetryPolicy) ClientOptionFunc {
+	return func(c *Client) error {
+		if policy == nil {
+			policy = NoRetry
+		}
+		c.retryPolicy = policy
+		return nil
+	}
+}
+
+// SetSuccessStatus replaces the set of response status codes that are
+// treated as success (200 and 201 by default). Any other status makes the
+// request fail with an *HTTPError.
+func SetSuccessStatus(codes ...int) ClientOptionFunc {
+	return func(c *Client) error {
+		if len(codes) == 0 {
+			return errors.New("net: no success status code given")
+		}
+		c.successStatus = make(map[int]bool, len(codes))
+		for _, code := range codes {
+			c.successStatus[code] = true
+		}
+		return nil
+	}
+}
+
+// SetCircuitBreaker makes the Client fail fast with a *CircuitOpenError
+// while the circuit of an upstream host is open. Transport errors and
+// responses with status 429 or 5xx count as failures. Several clients may
+// share one breaker.
+func SetCircuitBreaker(cb *CircuitBreaker) ClientOptionFunc {
+	return func(c *Client) error {
+		c.breaker = cb
+		return nil
+	}
+}
+
+// SetLimiter makes the Client respect the rate and concurrency limits of
+// the Limiter. Several clients may share one limiter.
+func SetLimiter(l *Limiter) ClientOptionFunc {
+	return func(c *Client) error {
+		c.limiter = l
+		return nil
+	}
+}
+
+// SetDialTimeout sets the connect timeout of the transport (10s by default).
+func SetDialTimeout(timeout time.Duration) ClientOptionFunc {
+	return func(c *Client) error {
+		c.dialer.Timeout = timeout
+		return nil
+	}
+}
+
+// SetKeepAlive sets the TCP keep-alive period of the transport.
+func SetKeepAlive(keepAlive time.Duration) ClientOptionFunc {
+	return func(c *Client) error {
+		c.dialer.KeepAlive = keepAlive
+		return nil
+	}
+}
+
+// SetMaxIdleConns sets the maximum number of idle connections kept across
+// all hosts (200 by default).
+func SetMaxIdleConns(n int) ClientOptionFunc {
+	return func(c *Client) error {
+		if c.transport != nil {
+			c.transport.MaxIdleConns = n
+		}
+		return nil
+	}
+}
+
+// SetMaxIdleConnsPerHost sets the maximum number of idle connections kept
+// per host (10 by default).
+func SetMaxIdleConnsPerHost(n int) ClientOptionFunc {
+	return func(c *Client) error {
+		if c.transport != nil {
+			c.transport.MaxIdleConnsPerHost = n
+		}
+		return nil
+	}
+}
+
+// SetMaxConnsPerHost limits the number of connections per host, including
+// connections in the dialing, active, and idle states. Zero means no limit.
+func SetMaxConnsPerHost(n int) ClientOptionFunc {
+	return func(c *Client) error {
+		if c.transport != nil {
+			c.transport.MaxConnsPerHost = n
+		}
+		return nil
+	}
+}
+
+// SetIdleConnTimeout sets how long an idle connection stays in the pool
+// (90s by default).
+func SetIdleConnTimeout(timeout time.Duration) ClientOptionFunc {
+	return func(c *Client) error {
+		if c.transport != nil {
+			c.transport.IdleConnTimeout = timeout
+		}
+		return nil
+	}
+}
+
+// SetResponseHeaderTimeout sets how long to wait for the response headers
+// after the request was written (10s by default).
+func SetResponseHeaderTimeout(timeout time.Duration) ClientOptionFunc {
+	return func(c *Client) error {
+		if c.transport != nil {
+			c.transport.ResponseHeaderTimeout = timeout
+		}
+		return nil
+	}
+}
+
+// SetTLSHandshakeTimeout sets the TLS handshake timeout of the transport.
+func SetTLSHandshakeTimeout(timeout time.Duration) ClientOptionFunc {
+	return func(c *Client) error {
+		if c.transport != nil {
+			c.transport.TLSHandshakeTimeout = timeout
+		}
+		return nil
+	}
+}
+
+// HTTPClient returns the underlying http.Client.
+func (c *Client) HTTPClient() *http.Client {
+	return c.c
+}
+
+func (c *Client) Post(url string, data []byte, a ...int) ([]byte, error) {
+	return c.PostWithHeaderCtx(context.Background(), url, nil, data, a...)
+}
+
+func (c *Client) PostCtx(ctx context.Context, url string, data []byte,
+	a ...int) ([]byte, error) {
+	return c.PostWithHeaderCtx(ctx, url, nil, data, a...)
+}
+
+func (c *Client) PostWithHeader(url string, header map[string]string,
+	data []byte, a ...int) ([]byte, error) {
+	return c.PostWithHeaderCtx(context.Background(), url, header, data, a...)
+}
+
+func (c *Client) PostWithHeaderCtx(ctx context.Context, url string,
+	header map[string]string, data []byte, a ...int) ([]byte, error) {
+	return c.send(ctx, "POST", url, jsonHeader(header), data, a...)
+}
+
+func (c *Client) Put(url string, data []byte, a ...int) ([]byte, error) {
+	return c.PutWithHeaderCtx(context.Background(), url, nil, data, a...)
+}
+
+func (c *Client) PutCtx(ctx context.Context, url string, data []byte,
+	a ...int) ([]byte, error) {
+	return c.PutWithHeaderCtx(ctx, url, nil, data, a...)
+}
+
+func (c *Client) PutWithHeader(url string, header map[string]string,
+	data []byte, a ...int) ([]byte, error) {
+	return c.PutWithHeaderCtx(context.Background(), url, header, data, a...)
+}
+
+func (c *Client) PutWithHeaderCtx(ctx context.Context, url string,
+	header map[string]string, data []byte, a ...int) ([]byte, error) {
+	return c.send(ctx, "PUT", url, jsonHeader(header), data, a...)
+}
+
+func (c *Client) Get(url string, a ...int) ([]byte, error) {
+	return c.GetWithHeaderCtx(context.Background(), url, nil, a...)
+}
+
+func (c *Client) GetCtx(ctx context.Context, url string, a ...int) (
+	[]byte, error) {
+	return c.GetWithHeaderCtx(ctx, url, nil, a...)
+}
+
+func (c *Client) GetWithHeader(url string, header map[string]string,
+	a ...int) ([]byte, error) {
+	return c.GetWithHeaderCtx(context.Background(), url, header, a...)
+}
+
+func (c *Client) GetWithHeaderCtx(ctx context.Context, url string,
+	header map[string]string, a ...int) ([]byte, error) {
+	return c.send(ctx, "GET", url, header, nil, a...)
+}
+
+func (c *Client) Delete(url string, a ...int) ([]byte, error) {
+	return c.DeleteWithHeaderCtx(context.Background(), url, nil, a...)
+}
+
+func (c *Client) DeleteCtx(ctx context.Context, url string, a ...int) (
+	[]byte, error) {
+	return c.DeleteWithHeaderCtx(ctx, url, nil, a...)
+}
+
+func (c *Client) DeleteWithHeader(url string, header map[string]string,
+	a ...int) ([]byte, error) {
+	return c.DeleteWithHeaderCtx(context.Background(), url, header, a...)
+}
+
+func (c *Client) DeleteWithHeaderCtx(ctx context.Context, url string,
+	header map[string]string, a ...int) ([]byte, error) {
+	return c.send(ctx, "DELETE", url, header, nil, a...)
+}
+
+// send resolves the optional timeout/retry parameters against the client
+// defaults and runs the request through RetryDoRequestWithPolicyCtx.
+func (c *Client) send(ctx context.Context, reqType, url string,
+	header map[string]string, data []byte, a ...int) ([]byte, error) {
+	timeout, policy, err := c.parseParameters(a...)
+	if err != nil {
+		return nil, err
+	}
+	return c.RetryDoRequestWithPolicyCtx(ctx, reqType, url, header, data,
+		timeout, policy)
+}
+
+// RetryDoRequest retries the request at most retryTimes times, sleeping
+// interval milliseconds between two attempts.
+func (c *Client) RetryDoRequest(reqType, URL string, headers map[string]string,
+	data []byte, timeout, retryTimes, interval int) ([]byte, error) {
+	return c.RetryDoRequestCtx(context.Background(), reqType, URL, headers,
+		data, timeout, retryTimes, interval)
+}
+
+// RetryDoRequestCtx is like RetryDoRequest but stops retrying as soon as
+// ctx is done.
+func (c *Client) RetryDoRequestCtx(ctx context.Context, reqType, URL string,
+	headers map[string]string, data []byte, timeout, retryTimes,
+	interval int) ([]byte, error) {
+	policy := NewRetryPolicy(retryTimes,
+		NewConstantBackoff(time.Duration(interval)*time.Millisecond))
+	return c.RetryDoRequestWithPolicyCtx(ctx, reqType, URL, headers, data,
+		timeout, policy)
+}
+
+// RetryDoRequestWithPolicy sends the request and retries it as long as
+// the policy allows.
+func (c *Client) RetryDoRequestWithPolicy(reqType, URL string,
+	headers map[string]string, data []byte, timeout int,
+	policy RetryPolicy) ([]byte, error) {
+	return c.RetryDoRequestWithPolicyCtx(context.Background(), reqType, URL,
+		headers, data, timeout, policy)
+}
+
+// RetryDoRequestWithPolicyCtx is like RetryDoRequestWithPolicy but stops
+// retrying as soon as ctx is done. A wait that would outlast the deadline
+// of ctx is not started.
+func (c *Client) RetryDoRequestWithPolicyCtx(ctx context.Context, reqType,
+	URL string, headers map[string]string, data []byte, timeout int,
+	policy RetryPolicy) ([]byte, error) {
+	_, body, _, err := c.retryDo(ctx, reqType, URL, headers, data, timeout,
+		policy)
+	return body, err
+}
+
+// retryDo runs the retry loop and returns the result of the last attempt.
+func (c *Client) retryDo(ctx context.Context, reqType, URL string,
+	headers map[string]string, data []byte, timeout int,
+	policy RetryPolicy) (int, []byte, map[string][]string, error) {
+	var statusCode int
+	var body []byte
+	var header map[string][]string
+	err := c.retryLoop(ctx, URL, policy, func() (int, map[string][]string, error) {
+		var err error
+		statusCode, body, header, err = c.DoRequestCtx(ctx, reqType, URL,
+			headers, data, timeout)
+		return statusCode, header, err
+	})
+	return statusCode, body, header, err
+}
+
+// retryLoop calls attempt until it succeeds, ctx is done or the policy
+// gives up. A wait that would outlast the deadline of ctx is not started.
+// The returned error carries the number of attempts.
+func (c *Client) retryLoop(ctx context.Context, URL string, policy RetryPolicy,
+	attempt func() (int, map[string][]string, error)) error {
+	host := hostOf(c.resolveURL(URL))
+	start := time.Now()
+	var wait time.Duration
+	for i := 0; ; i++ {
+		statusCode, header, err := attempt()
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil || IsCircuitOpen(err) || IsRateLimited(err) {
+			return attemptsError(err, i+1)
+		}
+		next, ok := policy.Retry(&RetryAttempt{
+			Retry:      i,
+			Elapsed:    time.Since(start),
+			LastWait:   wait,
+			StatusCode: statusCode,
+			Header:     header,
+			Err:        err,
+		})
+		if deadline, has := ctx.Deadline(); ok && has &&
+			time.Until(deadline) < next {
+			ok = false
+		}
+		if !ok {
+			return attemptsError(err, i+1)
+		}
+		wait = next
+		if err := sleepCtx(ctx, wait); err != nil {
+			return attemptsError(err, i+1)
+		}
+		c.stats.recordRetry(host)
+	}
+}
+
+// DoRequest sends a single request and reads the whole response.
+// reqType is one of HTTP request strings (GET, POST, PUT, DELETE, etc.),
+// timeout is in milliseconds. A response status that is not a success
+// status of the client yields an *HTTPError.
+func (c *Client) DoRequest(reqType, url string, headers map[string]string,
+	data []byte, timeout int) (int, []byte, map[string][]string, error) {
+	return c.DoRequestCtx(context.Background(), reqType, url, headers, data,
+		timeout)
+}
+
+// DoRequestCtx is like DoRequest but the request is bound to ctx. The
+// attempt is cancelled together with ctx, and the timeout never extends
+// past the deadline of ctx.
+func (c *Client) DoRequestCtx(ctx context.Context, reqType, url string,
+	headers map[string]string, data []byte, timeout int) (int, []byte,
+	map[string][]string, error) {
+	var reader io.Reader
+	if len(data) > 0 {
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(reqType, c.resolveURL(url), reader)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	for k, v := range c.header {
+		req.Header.Set(k, v)
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	cached, fresh := c.cache.lookup(req)
+	if fresh {
+		return cached.StatusCode, append([]byte(nil), cached.Body...),
+			cached.Header.Clone(), nil
+	}
+
+	// waiting for the limiter does not count against the timeout
+	release, err := c.acquire(ctx, req)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	// The derived context expires at the earlier of the parent deadline
+	// and the per-attempt timeout.
+	to := time.Duration(timeout) * time.Millisecond
+	actx, cancel := context.WithTimeout(ctx, to)
+	defer cancel()
+	req = req.WithContext(actx)
+	resp, err := c.do(ctx, c.c, req, release)
+	if err != nil {
+		if c.cache.stale(cached) {
+			return cached.StatusCode, append([]byte(nil), cached.Body...),
+				cached.Header.Clone(), nil
+		}
+		return 0, nil, nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	statusCode := resp.StatusCode
+	header := resp.Header
+	if statusCode >= 500 && c.cache.stale(cached) {
+		return cached.StatusCode, append([]byte(nil), cached.Body...),
+			cached.Header.Clone(), nil
+	}
+	if c.cache != nil {
+		statusCode, header, body = c.cache.update(req, cached, statusCode,
+			header, body)
+		// the store keeps its own copy
+		body, header = append([]byte(nil), body...), header.Clone()
+	}
+	if !c.successStatus[statusCode] {
+		return statusCode, nil, header, newHTTPError(reqType, req.URL.String(),
+			statusCode, header, body)
+	}
+	return statusCode, body, header, nil
+}
+
+// attemptsError records the number of attempts in err. An *HTTPError
+// keeps its type, other errors are wrapped.
+func attemptsError(err error, attempts int) error {
+	if e, ok := err.(*HTTPError); ok {
+		e.Attempts = attempts
+		return e
+	}
+	return fmt.Errorf("%w[try %d times]", err, attempts)
+}
+
+// acquire waits until the limiter of the client admits req. ctx is the
+// context of the caller: the wait must not be bounded by the timeout of a
+// single attempt. release must be passed to do.
+func (c *Client) acquire(ctx context.Context, req *http.Request) (
+	release func(), err error) {
+	if c.limiter == nil {
+		return func() {}, nil
+	}
+	if release, err = c.limiter.Acquire(ctx, req.URL); err != nil {
+		closeRequestBody(req)
+	}
+	return release, err
+}
+
+// closeRequestBody closes the body of a request that will not be sent,
+// as http.Client.Do does on errors, so its producer can stop.
+func closeRequestBody(req *http.Request) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+}
+
+// do sends req with hc, respecting the circuit breaker of the client.
+// release, obtained from acquire, is called once the request is finished.
+// ctx is the context of the caller, req may carry a per-attempt timeout
+// derived from it.
+func (c *Client) do(ctx context.Context, hc *http.Client, req *http.Request,
+	release func()) (*http.Response, error) {
+	resp, err := c.doBreaker(ctx, hc, req)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	// the request is in flight until its body is closed
+	resp.Body = &releaseBody{ReadCloser: resp.Body, release: release}
+	return resp, nil
+}
+
+// doBreaker sends req unless the circuit of its host is open and records
+// the outcome in the breaker and the stats.
+func (c *Client) doBreaker(ctx context.Context, hc *http.Client,
+	req *http.Request) (*http.Response, error) {
+	var done func(Outcome)
+	if c.breaker != nil {
+		var err error
+		if done, err = c.breaker.Allow(req.URL.Host); err != nil {
+			closeRequestBody(req)
+			return nil, err
+		}
+	}
+	start := time.Now()
+	resp, err := hc.Do(req)
+	latency := time.Since(start)
+	if err != nil {
+		c.stats.recordRequest(req.URL.Host, 0, false, err, latency)
+		if done != nil {
+			// a caller giving up or running out of time says nothing
+			// about the upstream, only a per-attempt timeout does
+			if ctx.Err() != nil || errors.Is(err, context.Canceled) {
+				done(OutcomeIgnored)
+			} else {
+				done(OutcomeFailure)
+			}
+		}
+		return nil, err
+	}
+	c.stats.recordRequest(req.URL.Host, resp.StatusCode,
+		c.successStatus[resp.StatusCode], nil, latency)
+	if done != nil {
+		if resp.StatusCode == http.StatusTooManyRequests ||
+			resp.StatusCode >= 500 {
+			done(OutcomeFailure)
+		} else {
+			done(OutcomeSuccess)
+		}
+	}
+	return resp, nil
+}
+
+// releaseBody calls release when the response body is closed.
+type releaseBody struct {
+	io.ReadCloser
+	release func()
+}
+
+func (b *releaseBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.release()
+	return err
+}
+
+// sleepCtx sleeps for d or until ctx is done, whichever happens first.
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// hostOf returns the host of rawurl, or "" if it cannot be parsed.
+func hostOf(rawurl string) string {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return ""
+	}
+	return u.Host
+}
+
+// resolveURL prepends the base URL to relative request URLs.
+func (c *Client) resolveURL(url string) string {
+	if c.baseURL == "" || strings.Contains(url, "://") {
+		return url
+	}
+	return c.baseURL + "/" + strings.TrimLeft(url, "/")
+}
+
+// parseParameters resolves the optional call parameters,
+// a[0] - timeout, default is the client timeout
+// a[1] - retry times, default is the client retry policy
+// a[2] - retry period, required with a[1]
+// time unit is Millisecond
+func (c *Client) parseParameters(a ...int) (timeout int, policy RetryPolicy,
+	err error) {
+	if len(a) == 2 || len(a) > 3 {
+		err = errors.New("http Post retry parameters count error")
+		return
+	}
+	timeout = int(c.timeout / time.Millisecond)
+	if len(a) > 0 {
+		timeout = a[0]
+	}
+	policy = c.retryPolicy
+	if len(a) == 3 {
+		policy = NewRetryPolicy(a[1],
+			NewConstantBackoff(time.Duration(a[2])*time.Millisecond))
+	}
+	return
+}
+
+// jsonHeader returns header, or a JSON content type header if it is empty.
+func jsonHeader(header map[string]string) map[string]string {
+	if len(header) == 0 {
+		header = map[string]string{"Content-Type": "application/json"}
+	}
+	return header
+}
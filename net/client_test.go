@@ -0,0 +1,216 @@
+// Copyright 2018 JXB. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package net
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestNewClientDefaults(t *testing.T) {
+	c, err := NewClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr, ok := c.HTTPClient().Transport.(*http.Transport)
+	if !ok {
+		t.Fatalf("want *http.Transport, got %T", c.HTTPClient().Transport)
+	}
+	if tr.MaxIdleConns != DefaultMaxIdleConns {
+		t.Fatalf("MaxIdleConns want %d, got %d", DefaultMaxIdleConns,
+			tr.MaxIdleConns)
+	}
+	if c.timeout != DefaultTimeout || c.retryPolicy != NoRetry {
+		t.Fatalf("unexpected defaults: timeout %v, retry %v", c.timeout,
+			c.retryPolicy)
+	}
+	if GetClient() != DefaultClient().HTTPClient() {
+		t.Fatal("GetClient should return the default client's http.Client")
+	}
+}
+
+func TestNewClientOptions(t *testing.T) {
+	c, err := NewClient(
+		SetMaxIdleConns(5),
+		SetMaxIdleConnsPerHost(2),
+		SetDialTimeout(time.Second),
+		SetTimeout(300*time.Millisecond),
+		SetRetry(2, 50*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := c.HTTPClient().Transport.(*http.Transport)
+	if tr.MaxIdleConns != 5 || tr.MaxIdleConnsPerHost != 2 {
+		t.Fatalf("transport options not applied: %d, %d", tr.MaxIdleConns,
+			tr.MaxIdleConnsPerHost)
+	}
+	if c.dialer.Timeout != time.Second {
+		t.Fatalf("dial timeout want 1s, got %v", c.dialer.Timeout)
+	}
+	timeout, policy, err := c.parseParameters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, ok := policy.(*BackoffPolicy)
+	if timeout != 300 || !ok || p.maxRetries != 2 {
+		t.Fatalf("want 300 and 2 retries, got %d, %#v", timeout, policy)
+	}
+	if d := p.backoff.Next(0, 0); d != 50*time.Millisecond {
+		t.Fatalf("retry interval want 50ms, got %v", d)
+	}
+
+	if _, err := NewClient(SetTimeout(0)); err == nil {
+		t.Fatal("zero timeout should be rejected")
+	}
+	if _, err := NewClient(SetHTTPClient(nil)); err == nil {
+		t.Fatal("nil http client should be rejected")
+	}
+}
+
+func TestClientBaseURLAndHeaders(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			raw, _ := ioutil.ReadAll(req.Body)
+			io.WriteString(w, req.URL.Path+" "+req.Header.Get("App-Id")+" "+
+				req.Header.Get("Content-Type")+" "+string(raw))
+		}))
+	defer ts.Close()
+
+	c, err := NewClient(SetBaseURL(ts.URL+"/api/"), SetHeader("App-Id", "app_1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := c.Get("/users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(resp) != "/api/users app_1  " {
+		t.Fatalf("unexpected response: %q", resp)
+	}
+	resp, err = c.PostWithHeader(ts.URL+"/abs", map[string]string{
+		"App-Id": "app_2"}, []byte("hi"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(resp) != "/abs app_2  hi" {
+		t.Fatalf("unexpected response: %q", resp)
+	}
+	resp, err = c.Post("post", []byte("hi"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(resp) != "/api/post app_1 application/json hi" {
+		t.Fatalf("unexpected response: %q", resp)
+	}
+}
+
+func TestClientRetry(t *testing.T) {
+	var calls int32
+	ts := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			if atomic.AddInt32(&calls, 1) < 3 {
+				w.WriteHeader(503)
+				return
+			}
+			io.WriteString(w, "ok")
+		}))
+	defer ts.Close()
+
+	c, err := NewClient(SetRetry(2, 10*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := c.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(resp) != "ok" || atomic.LoadInt32(&calls) != 3 {
+		t.Fatalf("want ok after 3 calls, got %q after %d", resp, calls)
+	}
+}
+
+func TestRetryDoRequestCtxCancel(t *testing.T) {
+	var calls int32
+	ts := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			atomic.AddInt32(&calls, 1)
+			w.WriteHeader(503)
+		}))
+	defer ts.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	start := time.Now()
+	_, err := RetryDoRequestCtx(ctx, "GET", ts.URL, nil, nil, 1000, 10, 1000)
+	if err == nil {
+		t.Fatal("want error after cancel")
+	}
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Fatalf("retry sleep should be aborted on cancel, took %v", d)
+	}
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Fatalf("want 1 call before cancel, got %d", n)
+	}
+}
+
+func TestDoRequestCtxDeadline(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			time.Sleep(300 * time.Millisecond)
+			io.WriteString(w, "slow")
+		}))
+	defer ts.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	// the per-attempt timeout is longer than the deadline of ctx
+	_, _, _, err := DoRequestCtx(ctx, "GET", ts.URL, nil, nil, 5000)
+	if err == nil {
+		t.Fatal("want deadline error")
+	}
+	if d := time.Since(start); d > 250*time.Millisecond {
+		t.Fatalf("attempt should stop at the ctx deadline, took %v", d)
+	}
+}
+
+func TestRetryStopsBeforeDeadline(t *testing.T) {
+	var calls int32
+	ts := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			atomic.AddInt32(&calls, 1)
+			w.WriteHeader(503)
+		}))
+	defer ts.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := GetCtx(ctx, ts.URL, 1000, 3, 500); err == nil {
+		t.Fatal("want error")
+	}
+	if d := time.Since(start); d > 150*time.Millisecond {
+		t.Fatalf("should not wait past the deadline, took %v", d)
+	}
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Fatalf("want 1 call, got %d", n)
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright 2018 JXB. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package net
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// MaxErrorBodySize is the maximum number of response body bytes kept in
+// an HTTPError.
+const MaxErrorBodySize = 4096
+
+// HTTPError is returned when the upstream answered with a status code the
+// client does not consider a success.
+type HTTPError struct {
+	StatusCode int         // response status code
+	Header     http.Header // response header
+	Body       []byte      // response body, truncated to MaxErrorBodySize
+	URL        string      // request URL
+	Method     string      // request method
+	Attempts   int         // number of attempts made, 0 for a single request
+}
+
+// newHTTPError builds an HTTPError, keeping at most MaxErrorBodySize bytes
+// of body.
+func newHTTPError(method, url string, statusCode int, header http.Header,
+	body []byte) *HTTPError {
+	if len(body) > MaxErrorBodySize {
+		body = body[:MaxErrorBodySize]
+	}
+	return &HTTPError{
+		StatusCode: statusCode,
+		Header:     header,
+		Body:       append([]byte(nil), body...),
+		URL:        url,
+		Method:     method,
+	}
+}
+
+func (e *HTTPError) Error() string {
+	s := fmt.Sprintf("response status error: %d, %s %s", e.StatusCode,
+		e.Method, e.URL)
+	if e.Attempts > 0 {
+		s += fmt.Sprintf("[try %d times]", e.Attempts)
+	}
+	return s
+}
+
+// StatusCode returns the response status code carried by err, or 0 if
+// err is not an HTTPError.
+func StatusCode(err error) int {
+	var e *HTTPError
+	if errors.As(err, &e) {
+		return e.StatusCode
+	}
+	return 0
+}
+
+// IsStatus reports whether err is an HTTPError with the given status code.
+func IsStatus(err error, code int) bool {
+	return code != 0 && StatusCode(err) == code
+}
+
+// IsNotFound reports whether err is an HTTPError with status 404.
+func IsNotFound(err error) bool {
+	return IsStatus(err, http.StatusNotFound)
+}
+
+// IsClientError reports whether err is an HTTPError with a 4xx status.
+func IsClientError(err error) bool {
+	code := StatusCode(err)
+	return code >= 400 && code < 500
+}
+
+// IsServerError reports whether err is an HTTPError with a 5xx status.
+func IsServerError(err error) bool {
+	return StatusCode(err) >= 500
+}
+
+// IsRetryable reports whether retrying the request that failed with err
+// may succeed: the upstream answered with one of DefaultRetryableStatus,
+// or the request was sent but no response was received. Cancellation or
+// an expired deadline of the caller, an open circuit, a rate limit and an
+// invalid URL are not retryable. A timeout of a single attempt is, as it
+// is reported by the transport.
+func IsRetryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) ||
+		IsCircuitOpen(err) || IsRateLimited(err) {
+		return false
+	}
+	var e *HTTPError
+	if errors.As(err, &e) {
+		for _, code := range DefaultRetryableStatus {
+			if e.StatusCode == code {
+				return true
+			}
+		}
+		return false
+	}
+	var ue *url.Error
+	if errors.As(err, &ue) {
+		return ue.Op != "parse"
+	}
+	return !errors.Is(err, context.DeadlineExceeded)
+}
@@ -0,0 +1,131 @@
+// Copyright 2018 JXB. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package net
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHTTPError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			w.Header().Set("X-Reason", "missing")
+			w.WriteHeader(404)
+			w.Write([]byte(strings.Repeat("x", MaxErrorBodySize+10)))
+		}))
+	defer ts.Close()
+
+	_, err := Get(ts.URL+"/item", 1000, 2, 10)
+	var e *HTTPError
+	if !errors.As(err, &e) {
+		t.Fatalf("want *HTTPError, got %T: %v", err, err)
+	}
+	if e.StatusCode != 404 || e.Method != "GET" || e.URL != ts.URL+"/item" {
+		t.Fatalf("unexpected error fields: %#v", e)
+	}
+	if e.Header.Get("X-Reason") != "missing" {
+		t.Fatalf("header not kept: %v", e.Header)
+	}
+	if len(e.Body) != MaxErrorBodySize {
+		t.Fatalf("body should be truncated to %d, got %d", MaxErrorBodySize,
+			len(e.Body))
+	}
+	if e.Attempts != 1 {
+		t.Fatalf("404 is not retried, want 1 attempt, got %d", e.Attempts)
+	}
+	if !IsNotFound(err) || !IsClientError(err) || IsRetryable(err) {
+		t.Fatal("unexpected classification of 404")
+	}
+	if !IsNotFound(fmt.Errorf("wrapped: %w", err)) {
+		t.Fatal("IsNotFound should see through wrapping")
+	}
+}
+
+func TestErrorClassification(t *testing.T) {
+	if !IsRetryable(&HTTPError{StatusCode: 503}) || !IsServerError(&HTTPError{StatusCode: 503}) {
+		t.Fatal("503 should be a retryable server error")
+	}
+	if IsRetryable(&HTTPError{StatusCode: 500}) {
+		t.Fatal("500 should not be retryable")
+	}
+	if !IsRetryable(errors.New("connection reset")) {
+		t.Fatal("transport errors should be retryable")
+	}
+	if IsRetryable(context.Canceled) || IsRetryable(nil) {
+		t.Fatal("cancellation should not be retryable")
+	}
+	if IsRetryable(&CircuitOpenError{}) || IsRetryable(&RateLimitedError{}) {
+		t.Fatal("open circuit and rate limit must not be retryable")
+	}
+	if IsRetryable(fmt.Errorf("%w[try 1 times]", context.DeadlineExceeded)) {
+		t.Fatal("expired caller deadline must not be retryable")
+	}
+	if !IsRetryable(&url.Error{Op: "Get", URL: "http://x", Err: context.DeadlineExceeded}) {
+		t.Fatal("attempt timeout should be retryable")
+	}
+	if _, err := http.NewRequest("GET", "http://[::1", nil); IsRetryable(err) {
+		t.Fatal("invalid URL must not be retryable")
+	}
+	if StatusCode(errors.New("x")) != 0 {
+		t.Fatal("StatusCode of a plain error should be 0")
+	}
+}
+
+func TestSuccessStatus(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			w.WriteHeader(202)
+			w.Write([]byte("accepted"))
+		}))
+	defer ts.Close()
+
+	if _, err := Get(ts.URL); !IsStatus(err, 202) {
+		t.Fatalf("202 is not a default success status, got %v", err)
+	}
+	c, err := NewClient(SetSuccessStatus(200, 202, 204))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := c.Get(ts.URL)
+	if err != nil || string(resp) != "accepted" {
+		t.Fatalf("want accepted, got %q, %v", resp, err)
+	}
+}
+
+func TestAttemptsErrorWrapping(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	ts := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			time.Sleep(200 * time.Millisecond)
+		}))
+	defer ts.Close()
+
+	_, err := GetCtx(ctx, ts.URL)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("want wrapped DeadlineExceeded, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "[try 1 times]") {
+		t.Fatalf("attempt count missing: %v", err)
+	}
+}
@@ -0,0 +1,153 @@
+// Copyright 2018 JXB. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package net
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// FanoutResult is the outcome of the request to one URL of a fan-out.
+type FanoutResult struct {
+	URL        string
+	StatusCode int
+	Body       []byte
+	Header     map[string][]string
+	Err        error
+}
+
+// FanoutResults are the results of a fan-out in the order of its URLs.
+type FanoutResults []FanoutResult
+
+// Bodies returns the bodies of the successful requests.
+func (rs FanoutResults) Bodies() [][]byte {
+	var bodies [][]byte
+	for _, r := range rs {
+		if r.Err == nil {
+			bodies = append(bodies, r.Body)
+		}
+	}
+	return bodies
+}
+
+// Err returns the first error, or nil if all requests succeeded.
+func (rs FanoutResults) Err() error {
+	for _, r := range rs {
+		if r.Err != nil {
+			return r.Err
+		}
+	}
+	return nil
+}
+
+func GetHedged(ctx context.Context, urls []string, delay time.Duration,
+	a ...int) ([]byte, error) {
+	return DefaultClient().GetHedged(ctx, urls, delay, a...)
+}
+
+func Fanout(ctx context.Context, reqType string, urls []string,
+	headers map[string]string, data []byte, timeout int) FanoutResults {
+	return DefaultClient().Fanout(ctx, reqType, urls, headers, data, timeout)
+}
+
+// GetHedged gets the same resource from mirrored urls. It sends the
+// request to the first url, and whenever no response arrived within delay,
+// or the last request failed, a backup request to the next url. The first
+// successful response is returned and the other requests are cancelled.
+// If all fail, the error of the last one is returned. a is the optional
+// timeout of each request in milliseconds.
+func (c *Client) GetHedged(ctx context.Context, urls []string,
+	delay time.Duration, a ...int) ([]byte, error) {
+	if len(urls) == 0 {
+		return nil, errors.New("http GetHedged without urls")
+	}
+	if len(a) > 1 {
+		return nil, errors.New("http GetHedged parameters count error")
+	}
+	timeout := int(c.timeout / time.Millisecond)
+	if len(a) > 0 {
+		timeout = a[0]
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	type result struct {
+		body []byte
+		err  error
+	}
+	results := make(chan result, len(urls))
+	send := func(url string) {
+		_, body, _, err := c.DoRequestCtx(ctx, "GET", url, nil, nil, timeout)
+		results <- result{body, err}
+	}
+
+	next, pending := 1, 1
+	go send(urls[0])
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	var lastErr error
+	for pending > 0 {
+		select {
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				return r.body, nil
+			}
+			lastErr = r.err
+			if next < len(urls) {
+				go send(urls[next])
+				next++
+				pending++
+				if !timer.Stop() {
+					select {
+					case <-timer.C:
+					default:
+					}
+				}
+				timer.Reset(delay)
+			}
+		case <-timer.C:
+			if next < len(urls) {
+				go send(urls[next])
+				next++
+				pending++
+				timer.Reset(delay)
+			}
+		}
+	}
+	return nil, lastErr
+}
+
+// Fanout sends the same request to all urls concurrently with DoRequest
+// and returns all results in the order of urls, so that the caller can
+// merge them. timeout is in milliseconds.
+func (c *Client) Fanout(ctx context.Context, reqType string, urls []string,
+	headers map[string]string, data []byte, timeout int) FanoutResults {
+	results := make(FanoutResults, len(urls))
+	var wg sync.WaitGroup
+	for i, url := range urls {
+		wg.Add(1)
+		go func(r *FanoutResult, url string) {
+			defer wg.Done()
+			r.URL = url
+			r.StatusCode, r.Body, r.Header, r.Err = c.DoRequestCtx(ctx,
+				reqType, url, headers, data, timeout)
+		}(&results[i], url)
+	}
+	wg.Wait()
+	return results
+}
@@ -0,0 +1,112 @@
+// Copyright 2018 JXB. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package net
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func mirror(body string, delay time.Duration, status int,
+	cancelled chan<- string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			select {
+			case <-time.After(delay):
+			case <-req.Context().Done():
+				if cancelled != nil {
+					cancelled <- body
+				}
+				return
+			}
+			w.WriteHeader(status)
+			w.Write([]byte(body))
+		}))
+}
+
+func TestGetHedged(t *testing.T) {
+	cancelled := make(chan string, 2)
+	slow := mirror("slow", 2*time.Second, 200, cancelled)
+	defer slow.Close()
+	fast := mirror("fast", 0, 200, nil)
+	defer fast.Close()
+
+	start := time.Now()
+	body, err := GetHedged(context.Background(),
+		[]string{slow.URL, fast.URL}, 50*time.Millisecond, 5000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != "fast" {
+		t.Fatalf("want fast, got %q", body)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("hedged request took %s", d)
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("the slow request was not cancelled")
+	}
+}
+
+func TestGetHedgedFailover(t *testing.T) {
+	bad := mirror("bad", 0, 500, nil)
+	defer bad.Close()
+	good := mirror("good", 0, 200, nil)
+	defer good.Close()
+
+	// a failed request starts the backup at once
+	start := time.Now()
+	body, err := GetHedged(context.Background(),
+		[]string{bad.URL, good.URL}, time.Hour)
+	if err != nil || string(body) != "good" {
+		t.Fatalf("want good, got %q, %v", body, err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("failover took %s", d)
+	}
+
+	_, err = GetHedged(context.Background(), []string{bad.URL, bad.URL},
+		time.Millisecond)
+	if !IsStatus(err, 500) {
+		t.Fatalf("want 500 error, got %v", err)
+	}
+}
+
+func TestFanout(t *testing.T) {
+	a := mirror("a", 0, 200, nil)
+	defer a.Close()
+	b := mirror("b", 20*time.Millisecond, 200, nil)
+	defer b.Close()
+	bad := mirror("bad", 0, 404, nil)
+	defer bad.Close()
+
+	rs := Fanout(context.Background(), "GET",
+		[]string{b.URL, bad.URL, a.URL}, nil, nil, 1000)
+	if len(rs) != 3 || rs[0].URL != b.URL || rs[2].URL != a.URL {
+		t.Fatalf("unexpected results %+v", rs)
+	}
+	bodies := rs.Bodies()
+	if len(bodies) != 2 || string(bodies[0]) != "b" || string(bodies[1]) != "a" {
+		t.Fatalf("unexpected bodies %q", bodies)
+	}
+	if !IsNotFound(rs.Err()) || rs[1].StatusCode != 404 {
+		t.Fatalf("want 404 error, got %v", rs.Err())
+	}
+}
@@ -15,183 +15,132 @@
 package net
 
 import (
-	"bytes"
 	"context"
-	"errors"
-	"fmt"
-	"io"
-	"io/ioutil"
-	"net"
 	"net/http"
 	"sync"
-	"time"
 )
 
 var (
-	client     *http.Client
-	clientOnce sync.Once
+	defaultClient     *Client
+	defaultClientOnce sync.Once
 )
 
+// DefaultClient returns the Client used by the package level functions.
+func DefaultClient() *Client {
+	defaultClientOnce.Do(func() {
+		c, err := NewClient()
+		if err != nil {
+			panic(err)
+		}
+		defaultClient = c
+	})
+	return defaultClient
+}
+
 func Post(url string, data []byte, a ...int) ([]byte, error) {
-	return PostWithHeader(url, nil, data, a...)
+	return DefaultClient().Post(url, data, a...)
+}
+
+func PostCtx(ctx context.Context, url string, data []byte, a ...int) (
+	[]byte, error) {
+	return DefaultClient().PostCtx(ctx, url, data, a...)
 }
 
 func PostWithHeader(url string, header map[string]string, data []byte,
 	a ...int) ([]byte, error) {
-	timeout, retryTimes, interval, err := parseParameters(a...)
-	if err != nil {
-		return nil, err
-	}
-	if len(header) == 0 {
-		header = make(map[string]string)
-		header["Content-Type"] = "application/json"
-	}
-	return RetryDoRequest("POST", url, header, data, timeout, retryTimes, interval)
+	return DefaultClient().PostWithHeader(url, header, data, a...)
+}
+
+func PostWithHeaderCtx(ctx context.Context, url string,
+	header map[string]string, data []byte, a ...int) ([]byte, error) {
+	return DefaultClient().PostWithHeaderCtx(ctx, url, header, data, a...)
 }
 
 func Put(url string, data []byte, a ...int) ([]byte, error) {
-	return PutWithHeader(url, nil, data, a...)
+	return DefaultClient().Put(url, data, a...)
+}
+
+func PutCtx(ctx context.Context, url string, data []byte, a ...int) (
+	[]byte, error) {
+	return DefaultClient().PutCtx(ctx, url, data, a...)
 }
 
 func PutWithHeader(url string, header map[string]string, data []byte,
 	a ...int) ([]byte, error) {
-	timeout, retryTimes, interval, err := parseParameters(a...)
-	if err != nil {
-		return nil, err
-	}
-	if len(header) == 0 {
-		header = make(map[string]string)
-		header["Content-Type"] = "application/json"
-	}
-	return RetryDoRequest("PUT", url, header, data, timeout, retryTimes, interval)
+	return DefaultClient().PutWithHeader(url, header, data, a...)
+}
+
+func PutWithHeaderCtx(ctx context.Context, url string,
+	header map[string]string, data []byte, a ...int) ([]byte, error) {
+	return DefaultClient().PutWithHeaderCtx(ctx, url, header, data, a...)
 }
 
 func Get(url string, a ...int) ([]byte, error) {
-	return GetWithHeader(url, nil, a...)
+	return DefaultClient().Get(url, a...)
+}
+
+func GetCtx(ctx context.Context, url string, a ...int) ([]byte, error) {
+	return DefaultClient().GetCtx(ctx, url, a...)
 }
 
 func GetWithHeader(url string, header map[string]string, a ...int) (
 	[]byte, error) {
-	timeout, retryTimes, interval, err := parseParameters(a...)
-	if err != nil {
-		return nil, err
-	}
-	return RetryDoRequest("GET", url, header, nil, timeout, retryTimes, interval)
+	return DefaultClient().GetWithHeader(url, header, a...)
+}
+
+func GetWithHeaderCtx(ctx context.Context, url string,
+	header map[string]string, a ...int) ([]byte, error) {
+	return DefaultClient().GetWithHeaderCtx(ctx, url, header, a...)
 }
 
 func Delete(url string, a ...int) ([]byte, error) {
-	return DeleteWithHeader(url, nil, a...)
+	return DefaultClient().Delete(url, a...)
+}
+
+func DeleteCtx(ctx context.Context, url string, a ...int) ([]byte, error) {
+	return DefaultClient().DeleteCtx(ctx, url, a...)
 }
 
 func DeleteWithHeader(url string, header map[string]string, a ...int) (
 	[]byte, error) {
-	timeout, retryTimes, interval, err := parseParameters(a...)
-	if err != nil {
-		return nil, err
-	}
-	return RetryDoRequest("DELETE", url, header, nil, timeout, retryTimes, interval)
+	return DefaultClient().DeleteWithHeader(url, header, a...)
+}
+
+func DeleteWithHeaderCtx(ctx context.Context, url string,
+	header map[string]string, a ...int) ([]byte, error) {
+	return DefaultClient().DeleteWithHeaderCtx(ctx, url, header, a...)
 }
 
 func RetryDoRequest(reqType, URL string, headers map[string]string, data []byte,
 	timeout, retryTimes, interval int) ([]byte, error) {
-	var err1 error
-	for i := 0; i < retryTimes+1; i++ {
-		_, body, _, err := DoRequest(reqType, URL, headers, data, timeout)
-		//if _, ok := err.(*url.Error); ok {
-		//	return nil, err
-		//}
-		//if statusCode == 404 {
-		//	return nil, err
-		//}
-		if err != nil {
-			err1 = errors.New(fmt.Sprintf("%s[try %d times]", err, i+1))
-			time.Sleep(time.Duration(interval) * time.Millisecond)
-			continue
-		}
-		return body, nil
-	}
-	return nil, err1
+	return DefaultClient().RetryDoRequest(reqType, URL, headers, data, timeout,
+		retryTimes, interval)
+}
+
+// RetryDoRequestCtx is like RetryDoRequest but stops retrying as soon as
+// ctx is done.
+func RetryDoRequestCtx(ctx context.Context, reqType, URL string,
+	headers map[string]string, data []byte, timeout, retryTimes,
+	interval int) ([]byte, error) {
+	return DefaultClient().RetryDoRequestCtx(ctx, reqType, URL, headers, data,
+		timeout, retryTimes, interval)
 }
 
 // reqType is one of HTTP request strings (GET, POST, PUT, DELETE, etc.)
 func DoRequest(reqType, url string, headers map[string]string, data []byte,
 	timeout int) (int, []byte, map[string][]string, error) {
-	var reader io.Reader
-	if data != nil && len(data) > 0 {
-		reader = bytes.NewReader(data)
-	}
-	req, err := http.NewRequest(reqType, url, reader)
-	if err != nil {
-		return 0, nil, nil, err
-	}
-	//req.Header.Set("User-Agent", "X11;Linux x86_64")
-	for k, v := range headers {
-		req.Header.Set(k, v)
-	}
-
-	to := time.Duration(time.Duration(timeout) * time.Millisecond)
-	ctx, cancel := context.WithTimeout(context.Background(), to)
-	defer cancel()
-	req = req.WithContext(ctx)
-	client := GetClient()
-	resp, err := client.Do(req)
-	if err != nil {
-		return 0, nil, nil, err
-	}
-	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return 0, nil, nil, err
-	}
-	statusCode := resp.StatusCode
-	header := resp.Header
-	if statusCode != 200 && statusCode != 201 {
-		return statusCode, nil, header, errors.New(
-			fmt.Sprintf("response status error: %d", statusCode))
-	}
-	return statusCode, body, header, nil
+	return DefaultClient().DoRequest(reqType, url, headers, data, timeout)
 }
 
+// DoRequestCtx is like DoRequest but the request is bound to ctx.
+func DoRequestCtx(ctx context.Context, reqType, url string,
+	headers map[string]string, data []byte, timeout int) (int, []byte,
+	map[string][]string, error) {
+	return DefaultClient().DoRequestCtx(ctx, reqType, url, headers, data,
+		timeout)
+}
+
+// GetClient returns the http.Client of the default Client.
 func GetClient() *http.Client {
-	clientOnce.Do(func() {
-		client = &http.Client{
-			Transport: &http.Transport{
-				//Proxy: http.ProxyURL(proxyUrl),
-				DialContext: (&net.Dialer{
-					Timeout:   10 * time.Second,
-					KeepAlive: 90 * time.Minute, // default value may be 7200s
-					DualStack: true,
-				}).DialContext,
-				MaxIdleConns:        200,
-				MaxIdleConnsPerHost: 10,
-				IdleConnTimeout:     90 * time.Second,
-				//TLSHandshakeTimeout:   10 * time.Second,
-				ExpectContinueTimeout: 10 * time.Second,
-				ResponseHeaderTimeout: 10 * time.Second,
-			},
-			Timeout: 10 * time.Second,
-		}
-	})
-	return client
-}
-
-// Post a json via http,
-// a[0] - timeout, default is 1 ms
-// a[1] - retry times, default is 0 times
-// a[2] - retry period, default is 1000 ms
-// time unit is Millisecond
-func parseParameters(a ...int) (timeout, retryTimes, interval int, err error) {
-	if len(a) == 2 || len(a) > 3 {
-		err = errors.New("http Post retry parameters count error")
-		return
-	}
-	timeout = 1000
-	if len(a) > 0 {
-		timeout = a[0]
-	}
-	retryTimes, interval = 0, 10
-	if len(a) == 3 {
-		retryTimes, interval = a[1], a[2]
-	}
-	return
+	return DefaultClient().HTTPClient()
 }
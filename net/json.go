@@ -0,0 +1,154 @@
+// Copyright 2018 JXB. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package net
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// maxSnippetSize is the number of body bytes quoted in decode errors.
+const maxSnippetSize = 256
+
+// DecodeError is returned when a JSON response body cannot be decoded.
+type DecodeError struct {
+	Err         error  // error of the JSON decoder
+	ContentType string // content type of the response
+	Snippet     string // beginning of the response body
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("decode json response (content type %q) error: %v, "+
+		"body: %s", e.ContentType, e.Err, e.Snippet)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+func GetJSON(ctx context.Context, url string, out interface{},
+	a ...int) error {
+	return DefaultClient().GetJSON(ctx, url, out, a...)
+}
+
+func PostJSON(ctx context.Context, url string, in, out interface{},
+	a ...int) error {
+	return DefaultClient().PostJSON(ctx, url, in, out, a...)
+}
+
+func PutJSON(ctx context.Context, url string, in, out interface{},
+	a ...int) error {
+	return DefaultClient().PutJSON(ctx, url, in, out, a...)
+}
+
+func DeleteJSON(ctx context.Context, url string, out interface{},
+	a ...int) error {
+	return DefaultClient().DeleteJSON(ctx, url, out, a...)
+}
+
+// GetJSON gets url and decodes the JSON response into out.
+func (c *Client) GetJSON(ctx context.Context, url string, out interface{},
+	a ...int) error {
+	return c.DoJSON(ctx, "GET", url, nil, nil, out, a...)
+}
+
+// PostJSON posts in encoded as JSON to url and decodes the JSON response
+// into out.
+func (c *Client) PostJSON(ctx context.Context, url string, in, out interface{},
+	a ...int) error {
+	return c.DoJSON(ctx, "POST", url, nil, in, out, a...)
+}
+
+// PutJSON puts in encoded as JSON to url and decodes the JSON response
+// into out.
+func (c *Client) PutJSON(ctx context.Context, url string, in, out interface{},
+	a ...int) error {
+	return c.DoJSON(ctx, "PUT", url, nil, in, out, a...)
+}
+
+// DeleteJSON deletes url and decodes the JSON response into out.
+func (c *Client) DeleteJSON(ctx context.Context, url string, out interface{},
+	a ...int) error {
+	return c.DoJSON(ctx, "DELETE", url, nil, nil, out, a...)
+}
+
+// DoJSON sends in encoded as JSON, unless it is nil, and decodes the JSON
+// response into out, unless it is nil or the response is empty. The
+// optional parameters are the same as for Get. Gzip compressed responses
+// are decompressed transparently.
+func (c *Client) DoJSON(ctx context.Context, reqType, url string,
+	header map[string]string, in, out interface{}, a ...int) error {
+	timeout, policy, err := c.parseParameters(a...)
+	if err != nil {
+		return err
+	}
+	var data []byte
+	if in != nil {
+		if data, err = json.Marshal(in); err != nil {
+			return fmt.Errorf("encode json request error: %v", err)
+		}
+	}
+	h := map[string]string{
+		"Accept":          "application/json",
+		"Accept-Encoding": "gzip",
+	}
+	if in != nil {
+		h["Content-Type"] = "application/json; charset=utf-8"
+	}
+	for k, v := range header {
+		h[k] = v
+	}
+	_, body, respHeader, err := c.retryDo(ctx, reqType, url, h, data,
+		timeout, policy)
+	if err != nil {
+		return err
+	}
+	return decodeJSON(http.Header(respHeader), body, out)
+}
+
+// decodeJSON decompresses body if needed and decodes it into out.
+func decodeJSON(header http.Header, body []byte, out interface{}) error {
+	if strings.EqualFold(header.Get("Content-Encoding"), "gzip") {
+		zr, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return fmt.Errorf("gunzip response error: %v", err)
+		}
+		defer zr.Close()
+		if body, err = ioutil.ReadAll(zr); err != nil {
+			return fmt.Errorf("gunzip response error: %v", err)
+		}
+	}
+	if out == nil || len(bytes.TrimSpace(body)) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(body, out); err != nil {
+		snippet := body
+		if len(snippet) > maxSnippetSize {
+			snippet = snippet[:maxSnippetSize]
+		}
+		return &DecodeError{
+			Err:         err,
+			ContentType: header.Get("Content-Type"),
+			Snippet:     string(snippet),
+		}
+	}
+	return nil
+}
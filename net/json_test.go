@@ -0,0 +1,116 @@
+// Copyright 2018 JXB. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package net
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type jsonUser struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+func TestPostJSON(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			if req.Header.Get("Content-Type") != "application/json; charset=utf-8" ||
+				req.Header.Get("Accept") != "application/json" {
+				w.WriteHeader(400)
+				return
+			}
+			var u jsonUser
+			if err := json.NewDecoder(req.Body).Decode(&u); err != nil {
+				w.WriteHeader(400)
+				return
+			}
+			u.ID = 7
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(u)
+		}))
+	defer ts.Close()
+
+	var out jsonUser
+	if err := PostJSON(context.Background(), ts.URL, jsonUser{Name: "jxb"},
+		&out); err != nil {
+		t.Fatal(err)
+	}
+	if out.ID != 7 || out.Name != "jxb" {
+		t.Fatalf("unexpected response: %#v", out)
+	}
+}
+
+func TestGetJSONGzip(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			if req.Header.Get("Accept-Encoding") != "gzip" {
+				w.WriteHeader(400)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Content-Encoding", "gzip")
+			zw := gzip.NewWriter(w)
+			io.WriteString(zw, `{"id":1,"name":"zipped"}`)
+			zw.Close()
+		}))
+	defer ts.Close()
+
+	var out jsonUser
+	if err := GetJSON(context.Background(), ts.URL, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.ID != 1 || out.Name != "zipped" {
+		t.Fatalf("unexpected response: %#v", out)
+	}
+}
+
+func TestGetJSONDecodeError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			w.Header().Set("Content-Type", "text/html")
+			io.WriteString(w, "<html>maintenance</html>")
+		}))
+	defer ts.Close()
+
+	var out jsonUser
+	err := GetJSON(context.Background(), ts.URL, &out)
+	var e *DecodeError
+	if !errors.As(err, &e) {
+		t.Fatalf("want *DecodeError, got %T: %v", err, err)
+	}
+	if e.Snippet != "<html>maintenance</html>" || e.ContentType != "text/html" {
+		t.Fatalf("unexpected decode error: %#v", e)
+	}
+}
+
+func TestDeleteJSONEmptyBody(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			w.WriteHeader(200)
+		}))
+	defer ts.Close()
+
+	var out jsonUser
+	if err := DeleteJSON(context.Background(), ts.URL, &out); err != nil {
+		t.Fatalf("empty body should not fail: %v", err)
+	}
+}
@@ -0,0 +1,290 @@
+// Copyright 2018 JXB. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package net
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Limit configures the rate and concurrency limits of one host or URL
+// prefix.
+type Limit struct {
+	Rate        float64 // requests per second, 0 means unlimited
+	Burst       int     // requests that may be sent at once, at least 1
+	MaxInFlight int     // concurrent requests, 0 means unlimited
+	FailFast    bool    // fail with a *RateLimitedError instead of waiting
+}
+
+// RateLimitedError is returned when a request exceeds a Limit with
+// FailFast set, or when the next token arrives after the deadline of the
+// caller.
+type RateLimitedError struct {
+	Key        string        // host or URL prefix of the Limit
+	Reason     string        // "rate" or "concurrency"
+	RetryAfter time.Duration // when a token is available, 0 if unknown
+}
+
+func (e *RateLimitedError) Error() string {
+	return fmt.Sprintf("%s limit exceeded for %s", e.Reason, e.Key)
+}
+
+// IsRateLimited reports whether err was caused by a client side limit.
+func IsRateLimited(err error) bool {
+	var e *RateLimitedError
+	return errors.As(err, &e)
+}
+
+// defaultIdleTimeout is how long the budget of a host under the default
+// Limit is kept after its last request.
+const defaultIdleTimeout = time.Minute
+
+// Limiter applies Limits to outgoing requests. A request is matched
+// against the URL prefixes first, the longest prefix winning, then against
+// its host. Requests to other hosts get the default Limit, if any, with
+// one budget per host; budgets of hosts idle for a minute are dropped. A
+// Limiter is safe for concurrent use and may be shared by several clients.
+type Limiter struct {
+	mu           sync.Mutex
+	prefixes     []*limiter // sorted by descending key length
+	hosts        map[string]*limiter
+	defaultLimit *Limit
+	defaults     map[string]*limiter // per host budgets of defaultLimit
+	lastSweep    time.Time
+}
+
+// NewLimiter returns a Limiter without any limits.
+func NewLimiter() *Limiter {
+	return &Limiter{
+		hosts:    make(map[string]*limiter),
+		defaults: make(map[string]*limiter),
+	}
+}
+
+// SetHostLimit limits the requests to host, e.g. "api.example.com:8080".
+func (l *Limiter) SetHostLimit(host string, limit Limit) *Limiter {
+	l.mu.Lock()
+	l.hosts[host] = newLimiter(host, limit)
+	l.mu.Unlock()
+	return l
+}
+
+// SetPrefixLimit limits the requests whose URL starts with prefix, e.g.
+// "http://api.example.com/v1/search".
+func (l *Limiter) SetPrefixLimit(prefix string, limit Limit) *Limiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	lim := newLimiter(prefix, limit)
+	for i, p := range l.prefixes {
+		if p.key == prefix {
+			l.prefixes[i] = lim
+			return l
+		}
+	}
+	i := 0
+	for i < len(l.prefixes) && len(l.prefixes[i].key) >= len(prefix) {
+		i++
+	}
+	l.prefixes = append(l.prefixes, nil)
+	copy(l.prefixes[i+1:], l.prefixes[i:])
+	l.prefixes[i] = lim
+	return l
+}
+
+// SetDefaultLimit sets the Limit applied per host to requests not matched
+// by any other Limit.
+func (l *Limiter) SetDefaultLimit(limit Limit) *Limiter {
+	l.mu.Lock()
+	l.defaultLimit = &limit
+	l.defaults = make(map[string]*limiter)
+	l.mu.Unlock()
+	return l
+}
+
+// Acquire waits until a request to u may be sent according to its Limit,
+// or until ctx is done. If the deadline of ctx comes before the next
+// token, it fails at once with a *RateLimitedError. On success release
+// must be called once the request is finished.
+func (l *Limiter) Acquire(ctx context.Context, u *url.URL) (release func(),
+	err error) {
+	lim := l.match(u)
+	if lim == nil {
+		return func() {}, nil
+	}
+	return lim.acquire(ctx)
+}
+
+func (l *Limiter) match(u *url.URL) *limiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.prefixes) > 0 {
+		s := u.String()
+		for _, p := range l.prefixes {
+			if strings.HasPrefix(s, p.key) {
+				return p
+			}
+		}
+	}
+	if lim, ok := l.hosts[u.Host]; ok {
+		return lim
+	}
+	if l.defaultLimit == nil {
+		return nil
+	}
+	now := time.Now()
+	if now.Sub(l.lastSweep) >= defaultIdleTimeout {
+		l.sweep(now)
+	}
+	lim, ok := l.defaults[u.Host]
+	if !ok {
+		lim = newLimiter(u.Host, *l.defaultLimit)
+		l.defaults[u.Host] = lim
+	}
+	lim.used = now
+	return lim
+}
+
+// sweep drops the default budgets of hosts that have not been used for
+// defaultIdleTimeout and are back to their initial state, so that a new
+// budget for such a host behaves the same.
+func (l *Limiter) sweep(now time.Time) {
+	l.lastSweep = now
+	for host, lim := range l.defaults {
+		if now.Sub(lim.used) >= defaultIdleTimeout && lim.idle(now) {
+			delete(l.defaults, host)
+		}
+	}
+}
+
+// limiter enforces one Limit with a token bucket and a semaphore.
+type limiter struct {
+	key   string
+	limit Limit
+	sem   chan struct{}
+
+	used time.Time // last match, guarded by the mutex of the Limiter
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func newLimiter(key string, limit Limit) *limiter {
+	if limit.Burst < 1 {
+		limit.Burst = 1
+	}
+	lim := &limiter{
+		key:    key,
+		limit:  limit,
+		tokens: float64(limit.Burst),
+		last:   time.Now(),
+	}
+	if limit.MaxInFlight > 0 {
+		lim.sem = make(chan struct{}, limit.MaxInFlight)
+	}
+	return lim
+}
+
+// idle reports whether no request is in flight and the bucket is full.
+func (lim *limiter) idle(now time.Time) bool {
+	if len(lim.sem) > 0 {
+		return false
+	}
+	if lim.limit.Rate <= 0 {
+		return true
+	}
+	lim.mu.Lock()
+	defer lim.mu.Unlock()
+	tokens := lim.tokens + now.Sub(lim.last).Seconds()*lim.limit.Rate
+	return tokens >= float64(lim.limit.Burst)
+}
+
+func (lim *limiter) acquire(ctx context.Context) (func(), error) {
+	if err := lim.wait(ctx); err != nil {
+		return nil, err
+	}
+	if lim.sem == nil {
+		return func() {}, nil
+	}
+	if lim.limit.FailFast {
+		select {
+		case lim.sem <- struct{}{}:
+		default:
+			lim.refund()
+			return nil, &RateLimitedError{Key: lim.key, Reason: "concurrency"}
+		}
+	} else {
+		select {
+		case lim.sem <- struct{}{}:
+		case <-ctx.Done():
+			// the request is not sent, so it must not use up a token
+			lim.refund()
+			return nil, ctx.Err()
+		}
+	}
+	var once sync.Once
+	return func() { once.Do(func() { <-lim.sem }) }, nil
+}
+
+// wait takes a token from the bucket, waiting for one if needed.
+func (lim *limiter) wait(ctx context.Context) error {
+	if lim.limit.Rate <= 0 {
+		return nil
+	}
+	lim.mu.Lock()
+	now := time.Now()
+	lim.tokens += now.Sub(lim.last).Seconds() * lim.limit.Rate
+	if max := float64(lim.limit.Burst); lim.tokens > max {
+		lim.tokens = max
+	}
+	lim.last = now
+	if lim.tokens >= 1 {
+		lim.tokens--
+		lim.mu.Unlock()
+		return nil
+	}
+	d := time.Duration((1 - lim.tokens) / lim.limit.Rate * float64(time.Second))
+	if lim.limit.FailFast {
+		lim.mu.Unlock()
+		return &RateLimitedError{Key: lim.key, Reason: "rate", RetryAfter: d}
+	}
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < d {
+		lim.mu.Unlock()
+		return &RateLimitedError{Key: lim.key, Reason: "rate", RetryAfter: d}
+	}
+	// reserve the token now so that waiters are served in order
+	lim.tokens--
+	lim.mu.Unlock()
+	if err := sleepCtx(ctx, d); err != nil {
+		lim.refund()
+		return err
+	}
+	return nil
+}
+
+// refund returns a token taken by wait for a request that is not sent.
+func (lim *limiter) refund() {
+	if lim.limit.Rate <= 0 {
+		return
+	}
+	lim.mu.Lock()
+	lim.tokens++
+	lim.mu.Unlock()
+}
@@ -0,0 +1,194 @@
+// Copyright 2018 JXB. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package net
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLimiterRate(t *testing.T) {
+	l := NewLimiter().SetHostLimit("a", Limit{Rate: 20, Burst: 2})
+	u := &url.URL{Scheme: "http", Host: "a"}
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		release, err := l.Acquire(context.Background(), u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		release()
+	}
+	// 2 burst tokens, then 2 more at 20/s
+	if d := time.Since(start); d < 80*time.Millisecond || d > 300*time.Millisecond {
+		t.Fatalf("want about 100ms, took %v", d)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := l.Acquire(ctx, u); !IsRateLimited(err) {
+		t.Fatalf("acquire should respect the context deadline, got %v", err)
+	}
+}
+
+func TestLimiterFailFast(t *testing.T) {
+	l := NewLimiter().SetDefaultLimit(Limit{Rate: 1, Burst: 1, FailFast: true})
+	a := &url.URL{Scheme: "http", Host: "a"}
+	b := &url.URL{Scheme: "http", Host: "b"}
+	if _, err := l.Acquire(context.Background(), a); err != nil {
+		t.Fatal(err)
+	}
+	_, err := l.Acquire(context.Background(), a)
+	if !IsRateLimited(err) {
+		t.Fatalf("want RateLimitedError, got %v", err)
+	}
+	if _, err := l.Acquire(context.Background(), b); err != nil {
+		t.Fatalf("the default limit applies per host: %v", err)
+	}
+}
+
+func TestLimiterRefundsToken(t *testing.T) {
+	l := NewLimiter().SetHostLimit("a",
+		Limit{Rate: 1, Burst: 2, MaxInFlight: 1})
+	u := &url.URL{Scheme: "http", Host: "a"}
+	release, err := l.Acquire(context.Background(), u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err = l.Acquire(ctx, u); err != context.DeadlineExceeded {
+		t.Fatalf("want deadline waiting for a slot, got %v", err)
+	}
+	release()
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err = l.Acquire(ctx, u); err != nil {
+		t.Fatalf("token of the unsent request should be refunded: %v", err)
+	}
+}
+
+func TestLimiterDropsIdleDefaultBudgets(t *testing.T) {
+	l := NewLimiter().SetDefaultLimit(Limit{Rate: 100, Burst: 1})
+	for _, host := range []string{"a", "b"} {
+		release, err := l.Acquire(context.Background(),
+			&url.URL{Scheme: "http", Host: host})
+		if err != nil {
+			t.Fatal(err)
+		}
+		release()
+	}
+	past := time.Now().Add(-2 * defaultIdleTimeout)
+	l.mu.Lock()
+	l.defaults["a"].used = past
+	l.lastSweep = past
+	l.mu.Unlock()
+	time.Sleep(20 * time.Millisecond) // refill the buckets
+	if _, err := l.Acquire(context.Background(),
+		&url.URL{Scheme: "http", Host: "c"}); err != nil {
+		t.Fatal(err)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.defaults["a"]; ok || len(l.defaults) != 2 {
+		t.Fatalf("idle budget of a should be dropped, have %d", len(l.defaults))
+	}
+}
+
+func TestLimiterPrefix(t *testing.T) {
+	l := NewLimiter().
+		SetPrefixLimit("http://a/v1", Limit{MaxInFlight: 1, FailFast: true}).
+		SetPrefixLimit("http://a/v1/search", Limit{MaxInFlight: 2, FailFast: true})
+	search, _ := url.Parse("http://a/v1/search?q=x")
+	other, _ := url.Parse("http://a/v1/users")
+	for i := 0; i < 2; i++ {
+		if _, err := l.Acquire(context.Background(), search); err != nil {
+			t.Fatalf("longest prefix should win: %v", err)
+		}
+	}
+	release, err := l.Acquire(context.Background(), other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.Acquire(context.Background(), other); !IsRateLimited(err) {
+		t.Fatalf("want concurrency limit, got %v", err)
+	}
+	release()
+	if _, err := l.Acquire(context.Background(), other); err != nil {
+		t.Fatalf("released slot should be available: %v", err)
+	}
+}
+
+func TestClientRateWaitsBeyondAttemptTimeout(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {}))
+	defer ts.Close()
+	c, err := NewClient(SetLimiter(NewLimiter().SetDefaultLimit(
+		Limit{Rate: 5, Burst: 1})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the second request waits 200ms for a token, longer than the 50ms
+	// timeout of an attempt
+	for i := 0; i < 2; i++ {
+		if _, _, _, err := c.DoRequestCtx(context.Background(), "GET", ts.URL,
+			nil, nil, 50); err != nil {
+			t.Fatalf("request %d: %v", i, err)
+		}
+	}
+}
+
+func TestClientMaxInFlight(t *testing.T) {
+	var inFlight, peak int32
+	ts := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			n := atomic.AddInt32(&inFlight, 1)
+			for {
+				p := atomic.LoadInt32(&peak)
+				if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+					break
+				}
+			}
+			time.Sleep(30 * time.Millisecond)
+			atomic.AddInt32(&inFlight, -1)
+		}))
+	defer ts.Close()
+
+	u, _ := url.Parse(ts.URL)
+	c, err := NewClient(SetLimiter(NewLimiter().SetHostLimit(u.Host,
+		Limit{MaxInFlight: 2})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := c.Get(ts.URL); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if p := atomic.LoadInt32(&peak); p > 2 {
+		t.Fatalf("at most 2 requests should be in flight, saw %d", p)
+	}
+}
@@ -0,0 +1,249 @@
+// Copyright 2018 JXB. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package net
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"net/http/httputil"
+	"time"
+
+	"github.com/zlxtqbdgdgd/sailor/thirdparty/glog"
+)
+
+// RequestIDHeader is the header carrying the request ID.
+const RequestIDHeader = "X-Request-Id"
+
+// Middleware wraps a RoundTripper to observe or modify every request sent
+// by a Client, e.g. to add headers or record metrics. Middlewares must not
+// modify the request they are given, but a clone of it.
+type Middleware func(next http.RoundTripper) http.RoundTripper
+
+// RoundTripperFunc is an adapter to use an ordinary function as an
+// http.RoundTripper.
+type RoundTripperFunc func(req *http.Request) (*http.Response, error)
+
+// RoundTrip calls f(req).
+func (f RoundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// SetMiddleware appends middlewares to the chain of the Client. The first
+// middleware is the outermost one, it sees a request first and its
+// response last.
+func SetMiddleware(middlewares ...Middleware) ClientOptionFunc {
+	return func(c *Client) error {
+		c.middlewares = append(c.middlewares, middlewares...)
+		return nil
+	}
+}
+
+// chain wraps rt with the middlewares, the first one outermost.
+func chain(rt http.RoundTripper, middlewares []Middleware) http.RoundTripper {
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		rt = middlewares[i](rt)
+	}
+	return rt
+}
+
+type ctxKey int
+
+const requestIDKey ctxKey = iota
+
+// WithRequestID returns a copy of ctx carrying the request ID id.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+// RequestIDFromContext returns the request ID carried by ctx, if any.
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// NewRequestID returns a random 128 bit request ID in hex.
+func NewRequestID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// RequestIDMiddleware sets the X-Request-Id header of every request that
+// does not have one yet. The ID is taken from the request context, see
+// WithRequestID, so that it propagates from an incoming request to all
+// outgoing ones; a new one is generated otherwise.
+func RequestIDMiddleware() Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			if req.Header.Get(RequestIDHeader) != "" {
+				return next.RoundTrip(req)
+			}
+			id := RequestIDFromContext(req.Context())
+			if id == "" {
+				id = NewRequestID()
+			}
+			req = req.Clone(req.Context())
+			req.Header.Set(RequestIDHeader, id)
+			return next.RoundTrip(req)
+		})
+	}
+}
+
+// BasicAuthMiddleware sets HTTP basic authentication on every request.
+func BasicAuthMiddleware(username, password string) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			req = req.Clone(req.Context())
+			req.SetBasicAuth(username, password)
+			return next.RoundTrip(req)
+		})
+	}
+}
+
+// BearerAuthMiddleware sets an "Authorization: Bearer" header on every
+// request. token is called for each request, so it may refresh expired
+// tokens; its error fails the request.
+func BearerAuthMiddleware(token func() (string, error)) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			t, err := token()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			req.Header.Set("Authorization", "Bearer "+t)
+			return next.RoundTrip(req)
+		})
+	}
+}
+
+// Redacted replaces the values of sensitive headers in dumps and cassettes.
+const Redacted = "REDACTED"
+
+// DefaultRedactHeaders are the headers masked by DumpMiddleware and, by
+// default, by a Recorder.
+var DefaultRedactHeaders = []string{"Authorization", "Proxy-Authorization",
+	"Cookie", "Set-Cookie", "X-Api-Key"}
+
+// redactSet returns the canonical form of headers as a set.
+func redactSet(headers []string) map[string]bool {
+	redact := make(map[string]bool, len(headers))
+	for _, h := range headers {
+		redact[http.CanonicalHeaderKey(h)] = true
+	}
+	return redact
+}
+
+// redactHeader returns a copy of h with the values of the headers in
+// redact, given in canonical form, masked.
+func redactHeader(h http.Header, redact map[string]bool) http.Header {
+	dst := h.Clone()
+	for k, vs := range dst {
+		if redact[k] {
+			for i := range vs {
+				vs[i] = Redacted
+			}
+		}
+	}
+	return dst
+}
+
+// MaxDumpBodySize is the largest body written by DumpMiddleware. Larger
+// bodies and bodies of unknown length, e.g. streamed uploads and
+// downloads, are left out.
+const MaxDumpBodySize = 64 << 10
+
+// DumpMiddleware writes requests and responses, including small bodies,
+// to glog when the verbosity is at least level. The headers in
+// DefaultRedactHeaders are masked.
+func DumpMiddleware(level glog.Level) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			if !glog.V(level) {
+				return next.RoundTrip(req)
+			}
+			dump, req, err := dumpRequest(req)
+			if err == nil {
+				glog.Infof("http request:\n%s", dump)
+			} else {
+				glog.Infof("dump http request error: %v", err)
+			}
+			resp, err := next.RoundTrip(req)
+			if err != nil {
+				glog.Infof("http request %s %s error: %v", req.Method, req.URL,
+					err)
+				return resp, err
+			}
+			if dump, err := dumpResponse(resp); err == nil {
+				glog.Infof("http response:\n%s", dump)
+			} else {
+				glog.Infof("dump http response error: %v", err)
+			}
+			return resp, nil
+		})
+	}
+}
+
+// dumpRequest dumps req with its sensitive headers masked. As the body
+// is read for the dump, it returns a clone of req to send instead.
+func dumpRequest(req *http.Request) ([]byte, *http.Request, error) {
+	withBody := req.Body == nil || req.Body == http.NoBody ||
+		(req.ContentLength > 0 && req.ContentLength <= MaxDumpBodySize)
+	out := req.Clone(req.Context())
+	out.Header = redactHeader(req.Header, redactSet(DefaultRedactHeaders))
+	dump, err := httputil.DumpRequestOut(out, withBody)
+	if withBody && req.Body != nil && req.Body != http.NoBody {
+		// out.Body now replays the body that was read
+		req = req.Clone(req.Context())
+		req.Body = out.Body
+	}
+	return dump, req, err
+}
+
+// dumpResponse dumps resp with its sensitive headers masked. A dumped
+// body is replaced by a copy in resp.
+func dumpResponse(resp *http.Response) ([]byte, error) {
+	withBody := resp.ContentLength >= 0 &&
+		resp.ContentLength <= MaxDumpBodySize
+	header := resp.Header
+	resp.Header = redactHeader(header, redactSet(DefaultRedactHeaders))
+	dump, err := httputil.DumpResponse(resp, withBody)
+	resp.Header = header
+	return dump, err
+}
+
+// LatencyMiddleware calls record after every round trip with the time it
+// took until the response header arrived. statusCode is 0 if the round
+// trip failed.
+func LatencyMiddleware(record func(req *http.Request, statusCode int,
+	latency time.Duration, err error)) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			start := time.Now()
+			resp, err := next.RoundTrip(req)
+			statusCode := 0
+			if resp != nil {
+				statusCode = resp.StatusCode
+			}
+			record(req, statusCode, time.Since(start), err)
+			return resp, err
+		})
+	}
+}
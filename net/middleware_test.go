@@ -0,0 +1,215 @@
+// Copyright 2018 JXB. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package net
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func echoHeadersServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			io.WriteString(w, req.Header.Get(RequestIDHeader)+"|"+
+				req.Header.Get("Authorization")+"|"+req.Header.Get("X-Order"))
+		}))
+}
+
+func TestMiddlewareOrder(t *testing.T) {
+	ts := echoHeadersServer()
+	defer ts.Close()
+
+	mark := func(name string) Middleware {
+		return func(next http.RoundTripper) http.RoundTripper {
+			return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				req = req.Clone(req.Context())
+				req.Header.Set("X-Order", req.Header.Get("X-Order")+name)
+				return next.RoundTrip(req)
+			})
+		}
+	}
+	c, err := NewClient(SetMiddleware(mark("a"), mark("b")),
+		SetMiddleware(mark("c")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := c.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(string(resp), "|abc") {
+		t.Fatalf("middlewares should run in registration order, got %q", resp)
+	}
+}
+
+func TestRequestIDMiddleware(t *testing.T) {
+	ts := echoHeadersServer()
+	defer ts.Close()
+
+	c, err := NewClient(SetMiddleware(RequestIDMiddleware()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := WithRequestID(context.Background(), "req-1")
+	resp, err := c.GetCtx(ctx, ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(resp), "req-1|") {
+		t.Fatalf("request id should propagate from ctx, got %q", resp)
+	}
+	resp, err = c.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id := strings.SplitN(string(resp), "|", 2)[0]; len(id) != 32 {
+		t.Fatalf("want a generated request id, got %q", id)
+	}
+}
+
+func TestAuthMiddlewares(t *testing.T) {
+	ts := echoHeadersServer()
+	defer ts.Close()
+
+	c, err := NewClient(SetMiddleware(BasicAuthMiddleware("u", "p")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := c.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(resp) != "|Basic dTpw|" {
+		t.Fatalf("unexpected basic auth: %q", resp)
+	}
+
+	c, err = NewClient(SetMiddleware(BearerAuthMiddleware(
+		func() (string, error) { return "tok", nil })))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp, err = c.Get(ts.URL); err != nil {
+		t.Fatal(err)
+	}
+	if string(resp) != "|Bearer tok|" {
+		t.Fatalf("unexpected bearer auth: %q", resp)
+	}
+
+	c, err = NewClient(SetMiddleware(BearerAuthMiddleware(
+		func() (string, error) { return "", errors.New("no token") })))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.Get(ts.URL); err == nil || !strings.Contains(err.Error(), "no token") {
+		t.Fatalf("token error should fail the request, got %v", err)
+	}
+}
+
+func TestLatencyMiddleware(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(testHandler))
+	defer ts.Close()
+
+	var status int
+	var latency time.Duration
+	c, err := NewClient(SetMiddleware(LatencyMiddleware(
+		func(req *http.Request, statusCode int, d time.Duration, err error) {
+			status, latency = statusCode, d
+		})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Get(ts.URL); err != nil {
+		t.Fatal(err)
+	}
+	if status != 200 || latency < 200*time.Millisecond {
+		t.Fatalf("unexpected record: %d, %v", status, latency)
+	}
+}
+
+func TestMiddlewareKeepsUserClient(t *testing.T) {
+	hc := &http.Client{}
+	c, err := NewClient(SetHTTPClient(hc), SetMiddleware(RequestIDMiddleware()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hc.Transport != nil || c.HTTPClient() == hc {
+		t.Fatal("the user supplied http.Client must not be modified")
+	}
+}
+
+func TestDumpRedactsAndLimitsBodies(t *testing.T) {
+	req, _ := http.NewRequest("POST", "http://example.com/x",
+		strings.NewReader("small body"))
+	req.SetBasicAuth("user", "secret")
+	req.Header.Set("Cookie", "session=abc")
+	dump, sent, err := dumpRequest(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := string(dump); strings.Contains(s, "abc") ||
+		strings.Contains(s, req.Header.Get("Authorization")) ||
+		!strings.Contains(s, "Authorization: "+Redacted) ||
+		!strings.Contains(s, "small body") {
+		t.Fatalf("unexpected request dump:\n%s", s)
+	}
+	if body, _ := ioutil.ReadAll(sent.Body); string(body) != "small body" {
+		t.Fatalf("request body should still be sent, got %q", body)
+	}
+	if req.Header.Get("Cookie") != "session=abc" {
+		t.Fatal("the original request must not be modified")
+	}
+
+	// a streamed upload of unknown length is not buffered
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	req, _ = http.NewRequest("PUT", "http://example.com/x", pr)
+	dump, sent, err = dumpRequest(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sent != req {
+		t.Fatal("unknown length body should be left untouched")
+	}
+
+	resp := &http.Response{
+		StatusCode:    200,
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Set-Cookie": {"id=42"}},
+		ContentLength: -1,
+		Body:          ioutil.NopCloser(strings.NewReader("streamed")),
+	}
+	dump, err = dumpResponse(resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := string(dump); strings.Contains(s, "id=42") ||
+		strings.Contains(s, "streamed") {
+		t.Fatalf("unexpected response dump:\n%s", s)
+	}
+	if resp.Header.Get("Set-Cookie") != "id=42" {
+		t.Fatal("response header should be restored")
+	}
+	if body, _ := ioutil.ReadAll(resp.Body); string(body) != "streamed" {
+		t.Fatalf("response body should be untouched, got %q", body)
+	}
+}
@@ -0,0 +1,176 @@
+// Copyright 2018 JXB. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package net
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// FilePart is a file sent in a multipart/form-data request.
+type FilePart struct {
+	FieldName   string // name of the form field
+	FileName    string // file name reported to the server
+	ContentType string // defaults to application/octet-stream
+	// Open returns the content of the file. It is called once per attempt,
+	// so retried requests can send the file again.
+	Open func() (io.ReadCloser, error)
+}
+
+// FileFromPath returns a FilePart sending the file at path.
+func FileFromPath(fieldName, path string) FilePart {
+	return FilePart{
+		FieldName: fieldName,
+		FileName:  filepath.Base(path),
+		Open: func() (io.ReadCloser, error) {
+			return os.Open(path)
+		},
+	}
+}
+
+// FileFromReader returns a FilePart sending the content of r. The request
+// can only be retried if r is an io.Seeker.
+func FileFromReader(fieldName, fileName string, r io.Reader) FilePart {
+	used := false
+	return FilePart{
+		FieldName: fieldName,
+		FileName:  fileName,
+		Open: func() (io.ReadCloser, error) {
+			if used {
+				s, ok := r.(io.Seeker)
+				if !ok {
+					return nil, errors.New("reader of " + fileName +
+						" cannot be rewound")
+				}
+				if _, err := s.Seek(0, io.SeekStart); err != nil {
+					return nil, err
+				}
+			}
+			used = true
+			return ioutil.NopCloser(r), nil
+		},
+	}
+}
+
+func PostMultipart(ctx context.Context, url string, fields map[string]string,
+	files []FilePart, a ...int) ([]byte, error) {
+	return DefaultClient().PostMultipart(ctx, url, fields, files, a...)
+}
+
+// PostMultipart posts the form fields and files as multipart/form-data and
+// returns the response body. The body is encoded while it is sent, so
+// large files are never held in memory. The optional parameters are the
+// same as for Post; on retry the body is encoded again. As an upload may
+// take long, there is no per-attempt timeout unless one is given: the
+// request is bounded by ctx only.
+func (c *Client) PostMultipart(ctx context.Context, url string,
+	fields map[string]string, files []FilePart, a ...int) ([]byte, error) {
+	timeout, policy, err := c.parseParameters(a...)
+	if err != nil {
+		return nil, err
+	}
+	if len(a) == 0 {
+		timeout = 0
+	}
+	boundary := multipart.NewWriter(nil).Boundary()
+	// the encoder only starts, and opens the files, once an attempt is
+	// admitted by the limiter and the breaker and the body is read
+	rewind := func() (io.Reader, error) {
+		return &lazyBody{open: func() (io.Reader, error) {
+			return encodeMultipart(boundary, fields, files), nil
+		}}, nil
+	}
+	headers := map[string]string{
+		"Content-Type": "multipart/form-data; boundary=" + boundary,
+	}
+	body, _ := rewind()
+	rc, err := c.RetryDoStreamCtx(ctx, "POST", url, headers, body, rewind,
+		timeout, policy)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return ioutil.ReadAll(rc)
+}
+
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+// encodeMultipart returns a reader producing the multipart body. The
+// encoding runs in a goroutine that stops when the reader is closed.
+func encodeMultipart(boundary string, fields map[string]string,
+	files []FilePart) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		mw := multipart.NewWriter(pw)
+		if err := mw.SetBoundary(boundary); err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+		keys := make([]string, 0, len(fields))
+		for k := range fields {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if err := mw.WriteField(k, fields[k]); err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+		}
+		for _, f := range files {
+			if err := writeFilePart(mw, f); err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+		}
+		pw.CloseWithError(mw.Close())
+	}()
+	return pr
+}
+
+func writeFilePart(mw *multipart.Writer, f FilePart) error {
+	h := make(textproto.MIMEHeader)
+	h.Set("Content-Disposition", fmt.Sprintf(
+		`form-data; name="%s"; filename="%s"`,
+		quoteEscaper.Replace(f.FieldName), quoteEscaper.Replace(f.FileName)))
+	contentType := f.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	h.Set("Content-Type", contentType)
+	w, err := mw.CreatePart(h)
+	if err != nil {
+		return err
+	}
+	if f.Open == nil {
+		return errors.New("no content for file " + f.FileName)
+	}
+	r, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	_, err = io.Copy(w, r)
+	return err
+}
@@ -0,0 +1,190 @@
+// Copyright 2018 JXB. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package net
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func multipartServer(calls *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			if err := req.ParseMultipartForm(1 << 20); err != nil {
+				w.WriteHeader(400)
+				return
+			}
+			if atomic.AddInt32(calls, 1) == 1 {
+				w.WriteHeader(503)
+				return
+			}
+			var out []string
+			out = append(out, "title="+req.FormValue("title"))
+			for _, name := range []string{"image", "csv"} {
+				f, h, err := req.FormFile(name)
+				if err != nil {
+					out = append(out, name+":missing")
+					continue
+				}
+				raw, _ := ioutil.ReadAll(f)
+				f.Close()
+				out = append(out, fmt.Sprintf("%s:%s:%s:%s", name, h.Filename,
+					h.Header.Get("Content-Type"), raw))
+			}
+			w.Write([]byte(strings.Join(out, ",")))
+		}))
+}
+
+func TestPostMultipart(t *testing.T) {
+	var calls int32
+	ts := multipartServer(&calls)
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "multipart")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "photo.png")
+	if err := ioutil.WriteFile(path, []byte("PNGDATA"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	image := FileFromPath("image", path)
+	image.ContentType = "image/png"
+	csv := FileFromReader("csv", "rows.csv", bytes.NewReader([]byte("a,b")))
+
+	// the first attempt gets a 503, the body must be sent again
+	resp, err := PostMultipart(context.Background(), ts.URL,
+		map[string]string{"title": "hello"}, []FilePart{image, csv},
+		1000, 1, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "title=hello,image:photo.png:image/png:PNGDATA," +
+		"csv:rows.csv:application/octet-stream:a,b"
+	if string(resp) != want {
+		t.Fatalf("want %q, got %q", want, resp)
+	}
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Fatalf("want 2 calls, got %d", n)
+	}
+}
+
+// slowReader returns one byte of data per read after a delay.
+type slowReader struct {
+	data  string
+	delay time.Duration
+}
+
+func (r *slowReader) Read(p []byte) (int, error) {
+	if r.data == "" {
+		return 0, io.EOF
+	}
+	time.Sleep(r.delay)
+	n := copy(p[:1], r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func TestPostMultipartSlowUpload(t *testing.T) {
+	calls := int32(1) // skip the 503 of the first call
+	ts := multipartServer(&calls)
+	defer ts.Close()
+
+	// the upload takes longer than the default per-attempt timeout
+	csv := FileFromReader("csv", "rows.csv",
+		&slowReader{data: "a,b", delay: 400 * time.Millisecond})
+	resp, err := PostMultipart(context.Background(), ts.URL, nil,
+		[]FilePart{csv})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(resp), "csv:rows.csv:application/octet-stream:a,b") {
+		t.Fatalf("unexpected response %q", resp)
+	}
+}
+
+func TestPostMultipartUnseekableReader(t *testing.T) {
+	var calls int32
+	ts := multipartServer(&calls)
+	defer ts.Close()
+
+	r := ioutil.NopCloser(strings.NewReader("x"))
+	_, err := PostMultipart(context.Background(), ts.URL, nil,
+		[]FilePart{FileFromReader("csv", "rows.csv", r)}, 1000, 1, 10)
+	if err == nil {
+		t.Fatal("a reader that cannot be rewound should not be sent twice")
+	}
+}
+
+func TestPostMultipartMissingFile(t *testing.T) {
+	var calls int32
+	ts := multipartServer(&calls)
+	defer ts.Close()
+
+	_, err := PostMultipart(context.Background(), ts.URL, nil,
+		[]FilePart{FileFromPath("image", "/nonexistent/file")})
+	if err == nil || !strings.Contains(err.Error(), "no such file") {
+		t.Fatalf("want open error, got %v", err)
+	}
+}
+
+func TestPostMultipartRejectedByBreaker(t *testing.T) {
+	var calls int32
+	ts := multipartServer(&calls)
+	defer ts.Close()
+	cb := NewCircuitBreaker(BreakerSettings{ConsecutiveFailures: 1,
+		CoolDown: time.Minute})
+	c, err := NewClient(SetCircuitBreaker(cb))
+	if err != nil {
+		t.Fatal(err)
+	}
+	done, _ := cb.Allow(strings.TrimPrefix(ts.URL, "http://"))
+	done(OutcomeFailure)
+
+	var opened int32
+	file := FilePart{FieldName: "f", FileName: "f.txt",
+		Open: func() (io.ReadCloser, error) {
+			atomic.AddInt32(&opened, 1)
+			return ioutil.NopCloser(strings.NewReader("data")), nil
+		}}
+	before := runtime.NumGoroutine()
+	for i := 0; i < 50; i++ {
+		_, err := c.PostMultipart(context.Background(), ts.URL, nil,
+			[]FilePart{file})
+		if !IsCircuitOpen(err) {
+			t.Fatalf("want CircuitOpenError, got %v", err)
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+	if n := runtime.NumGoroutine(); n > before+5 {
+		t.Fatalf("encoder goroutines leaked: %d before, %d after", before, n)
+	}
+	if n := atomic.LoadInt32(&opened); n != 0 {
+		t.Fatalf("files must not be opened for rejected requests, got %d", n)
+	}
+}
@@ -0,0 +1,286 @@
+// Copyright 2018 JXB. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package net
+
+import (
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// DefaultRetryableStatus lists the response status codes that are worth
+// retrying by default: the upstream is throttling or temporarily
+// unavailable. Other non-success codes, e.g. 4xx, never succeed on retry.
+var DefaultRetryableStatus = []int{
+	http.StatusTooManyRequests,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+}
+
+// RetryAttempt describes a failed attempt and is passed to a RetryPolicy
+// to decide whether and when to try again.
+type RetryAttempt struct {
+	Retry      int           // number of retries done so far, 0 after the first attempt
+	Elapsed    time.Duration // time since the first attempt started
+	LastWait   time.Duration // wait before the failed attempt, 0 for the first one
+	StatusCode int           // response status, 0 if no response was received
+	Header     http.Header   // response header, nil if no response was received
+	Err        error         // error of the failed attempt
+}
+
+// RetryPolicy decides whether a failed request is retried.
+type RetryPolicy interface {
+	// Retry returns the time to wait before the next attempt. To stop
+	// retrying return false in the 2nd return value.
+	Retry(attempt *RetryAttempt) (time.Duration, bool)
+}
+
+// Backoff computes the time to wait before a retry.
+type Backoff interface {
+	// Next returns the wait before retry number retry (starting at 0).
+	// prev is the wait returned for the previous retry.
+	Next(retry int, prev time.Duration) time.Duration
+}
+
+// -- ConstantBackoff --
+
+// ConstantBackoff is a backoff policy that always returns the same delay.
+type ConstantBackoff struct {
+	interval time.Duration
+}
+
+// NewConstantBackoff returns a new ConstantBackoff.
+func NewConstantBackoff(interval time.Duration) *ConstantBackoff {
+	return &ConstantBackoff{interval: interval}
+}
+
+// Next implements Backoff for ConstantBackoff.
+func (b *ConstantBackoff) Next(retry int, prev time.Duration) time.Duration {
+	return b.interval
+}
+
+// -- ExponentialBackoff --
+
+// ExponentialBackoff doubles the delay on every retry, starting at the
+// initial delay and never exceeding the maximum. With jitter enabled
+// the delay is drawn from [delay/2 .. delay].
+type ExponentialBackoff struct {
+	initial time.Duration
+	max     time.Duration
+	jitter  bool
+}
+
+// NewExponentialBackoff returns an ExponentialBackoff backoff policy.
+// Use initial to set the first interval and max to set the maximum
+// wait interval.
+func NewExponentialBackoff(initial, max time.Duration) *ExponentialBackoff {
+	return &ExponentialBackoff{initial: initial, max: max}
+}
+
+// Jitter enables or disables jittering values.
+func (b *ExponentialBackoff) Jitter(flag bool) *ExponentialBackoff {
+	b.jitter = flag
+	return b
+}
+
+// Max returns the maximum delay.
+func (b *ExponentialBackoff) Max() time.Duration {
+	return b.max
+}
+
+// Next implements Backoff for ExponentialBackoff.
+func (b *ExponentialBackoff) Next(retry int, prev time.Duration) time.Duration {
+	d := math.Min(float64(b.initial)*math.Pow(2, float64(retry)), float64(b.max))
+	if b.jitter {
+		d = d/2 + rand.Float64()*d/2
+	}
+	return time.Duration(d)
+}
+
+// -- DecorrelatedJitterBackoff --
+
+// DecorrelatedJitterBackoff implements the "decorrelated jitter" strategy
+// described at https://aws.amazon.com/blogs/architecture/exponential-backoff-and-jitter/:
+// every delay is drawn from [base .. 3*prev], capped at max. It spreads
+// the retries of many concurrent callers better than plain exponential
+// backoff.
+type DecorrelatedJitterBackoff struct {
+	base time.Duration
+	max  time.Duration
+}
+
+// NewDecorrelatedJitterBackoff returns a DecorrelatedJitterBackoff policy.
+func NewDecorrelatedJitterBackoff(base, max time.Duration) *DecorrelatedJitterBackoff {
+	return &DecorrelatedJitterBackoff{base: base, max: max}
+}
+
+// Max returns the maximum delay.
+func (b *DecorrelatedJitterBackoff) Max() time.Duration {
+	return b.max
+}
+
+// Next implements Backoff for DecorrelatedJitterBackoff.
+func (b *DecorrelatedJitterBackoff) Next(retry int, prev time.Duration) time.Duration {
+	if prev < b.base {
+		prev = b.base
+	}
+	upper := 3 * prev
+	d := b.base
+	if upper > b.base {
+		d += time.Duration(rand.Int63n(int64(upper - b.base)))
+	}
+	if d > b.max {
+		d = b.max
+	}
+	return d
+}
+
+// -- BackoffPolicy --
+
+// BackoffPolicy is the RetryPolicy used by Client. It retries transport
+// errors and retryable status codes up to a maximum number of times,
+// waiting according to a Backoff in between. A Retry-After header sent
+// with the response is honored up to a maximum, and retrying stops once
+// the total elapsed time would exceed the configured maximum.
+type BackoffPolicy struct {
+	maxRetries        int
+	backoff           Backoff
+	maxElapsed        time.Duration
+	retryable         map[int]bool
+	respectRetryAfter bool
+	maxRetryAfter     time.Duration
+}
+
+// NewRetryPolicy returns a BackoffPolicy that retries at most maxRetries
+// times, waiting as computed by backoff.
+func NewRetryPolicy(maxRetries int, backoff Backoff) *BackoffPolicy {
+	p := &BackoffPolicy{
+		maxRetries:        maxRetries,
+		backoff:           backoff,
+		respectRetryAfter: true,
+	}
+	return p.RetryOn(DefaultRetryableStatus...)
+}
+
+// NoRetry is a RetryPolicy that never retries.
+var NoRetry RetryPolicy = NewRetryPolicy(0, NewConstantBackoff(0))
+
+// MaxElapsed caps the total time spent on a request including all retries
+// and waits. Zero means no cap.
+func (p *BackoffPolicy) MaxElapsed(d time.Duration) *BackoffPolicy {
+	p.maxElapsed = d
+	return p
+}
+
+// MaxRetryAfter caps the wait requested by a Retry-After header. When the
+// server asks for more, the request is not retried. It defaults to the
+// maximum delay of the Backoff, or MaxElapsed if the Backoff has none.
+// Without either, e.g. for a ConstantBackoff, a Retry-After longer than
+// the backoff delay is ignored and the policy keeps its own interval.
+func (p *BackoffPolicy) MaxRetryAfter(d time.Duration) *BackoffPolicy {
+	p.maxRetryAfter = d
+	return p
+}
+
+// retryAfterCap returns the longest Retry-After wait honored, or false if
+// the policy has no cap.
+func (p *BackoffPolicy) retryAfterCap() (time.Duration, bool) {
+	if p.maxRetryAfter > 0 {
+		return p.maxRetryAfter, true
+	}
+	if b, ok := p.backoff.(interface{ Max() time.Duration }); ok && b.Max() > 0 {
+		return b.Max(), true
+	}
+	if p.maxElapsed > 0 {
+		return p.maxElapsed, true
+	}
+	return 0, false
+}
+
+// RetryOn replaces the set of response status codes that are retried.
+func (p *BackoffPolicy) RetryOn(codes ...int) *BackoffPolicy {
+	p.retryable = make(map[int]bool, len(codes))
+	for _, code := range codes {
+		p.retryable[code] = true
+	}
+	return p
+}
+
+// RespectRetryAfter enables or disables honoring the Retry-After header
+// (enabled by default).
+func (p *BackoffPolicy) RespectRetryAfter(flag bool) *BackoffPolicy {
+	p.respectRetryAfter = flag
+	return p
+}
+
+// IsRetryableStatus reports whether the policy retries the status code.
+func (p *BackoffPolicy) IsRetryableStatus(code int) bool {
+	return p.retryable[code]
+}
+
+// Retry implements RetryPolicy for BackoffPolicy.
+func (p *BackoffPolicy) Retry(a *RetryAttempt) (time.Duration, bool) {
+	if a.Retry >= p.maxRetries {
+		return 0, false
+	}
+	if a.StatusCode != 0 && !p.retryable[a.StatusCode] {
+		return 0, false
+	}
+	if a.StatusCode == 0 && a.Err != nil && !IsRetryable(a.Err) {
+		return 0, false
+	}
+	wait := p.backoff.Next(a.Retry, a.LastWait)
+	if p.respectRetryAfter {
+		if d, ok := parseRetryAfter(a.Header); ok && d > wait {
+			if max, capped := p.retryAfterCap(); !capped {
+				// keep the backoff interval
+			} else if d > max {
+				return 0, false
+			} else {
+				wait = d
+			}
+		}
+	}
+	if p.maxElapsed > 0 && a.Elapsed+wait >= p.maxElapsed {
+		return 0, false
+	}
+	return wait, true
+}
+
+// parseRetryAfter reads the Retry-After header, which is either a number
+// of seconds or an HTTP date.
+func parseRetryAfter(header http.Header) (time.Duration, bool) {
+	v := header.Get("Retry-After")
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs < 0 {
+			return 0, false
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		d := time.Until(t)
+		if d < 0 {
+			d = 0
+		}
+		return d, true
+	}
+	return 0, false
+}
@@ -0,0 +1,161 @@
+// Copyright 2018 JXB. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package net
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestExponentialBackoff(t *testing.T) {
+	b := NewExponentialBackoff(10*time.Millisecond, 50*time.Millisecond)
+	want := []time.Duration{10, 20, 40, 50, 50}
+	for i, w := range want {
+		if got := b.Next(i, 0); got != w*time.Millisecond {
+			t.Fatalf("retry %d: want %v, got %v", i, w*time.Millisecond, got)
+		}
+	}
+	b.Jitter(true)
+	for i := 0; i < 100; i++ {
+		got := b.Next(2, 0)
+		if got < 20*time.Millisecond || got > 40*time.Millisecond {
+			t.Fatalf("jittered backoff out of range: %v", got)
+		}
+	}
+}
+
+func TestDecorrelatedJitterBackoff(t *testing.T) {
+	b := NewDecorrelatedJitterBackoff(10*time.Millisecond, 100*time.Millisecond)
+	var prev time.Duration
+	for i := 0; i < 100; i++ {
+		got := b.Next(i, prev)
+		upper := 3 * prev
+		if upper < 30*time.Millisecond {
+			upper = 30 * time.Millisecond
+		}
+		if upper > 100*time.Millisecond {
+			upper = 100 * time.Millisecond
+		}
+		if got < 10*time.Millisecond || got > upper {
+			t.Fatalf("retry %d: %v out of [10ms .. %v]", i, got, upper)
+		}
+		prev = got
+	}
+}
+
+func TestBackoffPolicy(t *testing.T) {
+	p := NewRetryPolicy(2, NewConstantBackoff(10*time.Millisecond))
+	cases := []struct {
+		attempt RetryAttempt
+		retry   bool
+	}{
+		{RetryAttempt{Retry: 0, Err: errors.New("conn reset")}, true},
+		{RetryAttempt{Retry: 0, StatusCode: 503}, true},
+		{RetryAttempt{Retry: 0, StatusCode: 429}, true},
+		{RetryAttempt{Retry: 0, StatusCode: 404}, false},
+		{RetryAttempt{Retry: 0, StatusCode: 500}, false},
+		{RetryAttempt{Retry: 2, StatusCode: 503}, false},
+		{RetryAttempt{Retry: 0, Err: &CircuitOpenError{}}, false},
+		{RetryAttempt{Retry: 0, Err: context.Canceled}, false},
+	}
+	for i, c := range cases {
+		if _, ok := p.Retry(&c.attempt); ok != c.retry {
+			t.Fatalf("case %d: want retry %v, got %v", i, c.retry, ok)
+		}
+	}
+
+	header := http.Header{}
+	header.Set("Retry-After", "2")
+	wait, ok := p.Retry(&RetryAttempt{StatusCode: 429, Header: header})
+	if !ok || wait != 10*time.Millisecond {
+		t.Fatalf("Retry-After without a cap want 10ms, got %v, %v", wait, ok)
+	}
+	p.MaxRetryAfter(5 * time.Second)
+	if wait, ok = p.Retry(&RetryAttempt{StatusCode: 429, Header: header}); !ok || wait != 2*time.Second {
+		t.Fatalf("Retry-After within MaxRetryAfter want 2s, got %v, %v", wait, ok)
+	}
+	p.MaxRetryAfter(0).MaxElapsed(time.Second)
+	if _, ok = p.Retry(&RetryAttempt{StatusCode: 503, Header: header}); ok {
+		t.Fatal("Retry-After beyond MaxElapsed should stop retrying")
+	}
+	p.MaxElapsed(0)
+	exp := NewRetryPolicy(2, NewExponentialBackoff(10*time.Millisecond, time.Second))
+	if _, ok = exp.Retry(&RetryAttempt{StatusCode: 503, Header: header}); ok {
+		t.Fatal("Retry-After beyond the backoff max should stop retrying")
+	}
+	exp.MaxRetryAfter(5 * time.Second)
+	if wait, ok = exp.Retry(&RetryAttempt{StatusCode: 503, Header: header}); !ok || wait != 2*time.Second {
+		t.Fatalf("Retry-After within MaxRetryAfter want 2s, got %v, %v", wait, ok)
+	}
+
+	p.RespectRetryAfter(false)
+	if wait, _ = p.Retry(&RetryAttempt{StatusCode: 429, Header: header}); wait != 10*time.Millisecond {
+		t.Fatalf("Retry-After should be ignored, got %v", wait)
+	}
+
+	p.MaxElapsed(100 * time.Millisecond)
+	if _, ok = p.Retry(&RetryAttempt{StatusCode: 503, Elapsed: 95 * time.Millisecond}); ok {
+		t.Fatal("retry should stop when the elapsed time is exhausted")
+	}
+}
+
+func TestRetryDoRequestSkipsClientErrors(t *testing.T) {
+	var calls int32
+	ts := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			atomic.AddInt32(&calls, 1)
+			w.WriteHeader(404)
+		}))
+	defer ts.Close()
+
+	if _, err := RetryDoRequest("GET", ts.URL, nil, nil, 1000, 3, 10); err == nil {
+		t.Fatal("want error for 404")
+	}
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Fatalf("404 should not be retried, got %d calls", n)
+	}
+}
+
+func TestRetryDoRequestWithPolicy(t *testing.T) {
+	var calls int32
+	ts := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			if atomic.AddInt32(&calls, 1) == 1 {
+				w.Header().Set("Retry-After", "0")
+				w.WriteHeader(429)
+				return
+			}
+			w.Write([]byte("ok"))
+		}))
+	defer ts.Close()
+
+	c, err := NewClient(SetRetryPolicy(NewRetryPolicy(3,
+		NewExponentialBackoff(5*time.Millisecond, time.Second))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := c.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(resp) != "ok" || atomic.LoadInt32(&calls) != 2 {
+		t.Fatalf("want ok after 2 calls, got %q after %d", resp, calls)
+	}
+}
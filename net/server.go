@@ -0,0 +1,397 @@
+// Copyright 2018 JXB. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package net
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/zlxtqbdgdgd/sailor/thirdparty/glog"
+)
+
+const (
+	// DefaultDrainTimeout is how long a Server waits for in-flight
+	// requests on shutdown.
+	DefaultDrainTimeout = 15 * time.Second
+	// DefaultAccessLogFormat is the access log format of a Server, see
+	// AccessLogMiddleware for the variables.
+	DefaultAccessLogFormat = `$remote_addr "$method $uri $proto" $status ` +
+		`$bytes $duration_ms "$user_agent" $request_id`
+)
+
+// Server is an http.Server with graceful shutdown, panic recovery, an
+// access log, request IDs and health endpoints. Register handlers with
+// Handle and HandleFunc, then call ListenAndServe.
+type Server struct {
+	srv          *http.Server
+	mux          *http.ServeMux
+	drainTimeout time.Duration
+	signals      []os.Signal
+	logFormat    string    // access log format, empty disables the log
+	logWriter    io.Writer // access log destination, nil means glog
+
+	ready      int32 // 1 while the server accepts traffic
+	readyCheck map[string]func(ctx context.Context) error
+}
+
+// ServerOptionFunc is a function that configures a Server.
+// It is used in NewServer.
+type ServerOptionFunc func(*Server) error
+
+// NewServer creates a new Server listening on addr, e.g. ":8080". It
+// serves /healthz and /readyz, and logs every request to glog in the
+// DefaultAccessLogFormat.
+func NewServer(addr string, options ...ServerOptionFunc) (*Server, error) {
+	s := &Server{
+		srv: &http.Server{
+			Addr:              addr,
+			ReadHeaderTimeout: 10 * time.Second,
+			IdleTimeout:       DefaultIdleConnTimeout,
+		},
+		mux:          http.NewServeMux(),
+		drainTimeout: DefaultDrainTimeout,
+		signals:      []os.Signal{syscall.SIGTERM, os.Interrupt},
+		logFormat:    DefaultAccessLogFormat,
+		ready:        1,
+		readyCheck:   make(map[string]func(ctx context.Context) error),
+	}
+	for _, option := range options {
+		if err := option(s); err != nil {
+			return nil, err
+		}
+	}
+	s.mux.Handle("/healthz", HealthzHandler())
+	s.mux.HandleFunc("/readyz", s.serveReadyz)
+
+	var h http.Handler = s.mux
+	h = RecoverMiddleware(h)
+	if s.logFormat != "" {
+		h = AccessLogMiddleware(s.logFormat, s.logWriter)(h)
+	}
+	s.srv.Handler = RequestIDHandler(h)
+	return s, nil
+}
+
+// SetDrainTimeout sets how long in-flight requests may take to finish on
+// shutdown.
+func SetDrainTimeout(timeout time.Duration) ServerOptionFunc {
+	return func(s *Server) error {
+		s.drainTimeout = timeout
+		return nil
+	}
+}
+
+// SetShutdownSignals sets the signals starting a graceful shutdown, by
+// default SIGTERM and SIGINT.
+func SetShutdownSignals(signals ...os.Signal) ServerOptionFunc {
+	return func(s *Server) error {
+		s.signals = signals
+		return nil
+	}
+}
+
+// SetAccessLog sets the format and the destination of the access log. An
+// empty format disables the log, a nil w writes it to glog.
+func SetAccessLog(format string, w io.Writer) ServerOptionFunc {
+	return func(s *Server) error {
+		s.logFormat = format
+		s.logWriter = w
+		return nil
+	}
+}
+
+// SetServerTimeouts sets the read, write and idle timeouts of the
+// underlying http.Server. Zero means no timeout.
+func SetServerTimeouts(read, write, idle time.Duration) ServerOptionFunc {
+	return func(s *Server) error {
+		s.srv.ReadTimeout = read
+		s.srv.WriteTimeout = write
+		s.srv.IdleTimeout = idle
+		return nil
+	}
+}
+
+// SetReadyCheck adds a check to /readyz, e.g. a ping of the database.
+func SetReadyCheck(name string, check func(ctx context.Context) error) ServerOptionFunc {
+	return func(s *Server) error {
+		s.readyCheck[name] = check
+		return nil
+	}
+}
+
+// Handle registers the handler for the given pattern.
+func (s *Server) Handle(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, handler)
+}
+
+// HandleFunc registers the handler function for the given pattern.
+func (s *Server) HandleFunc(pattern string,
+	handler func(http.ResponseWriter, *http.Request)) {
+	s.mux.HandleFunc(pattern, handler)
+}
+
+// Handler returns the handler of the Server including all middlewares.
+func (s *Server) Handler() http.Handler {
+	return s.srv.Handler
+}
+
+// HTTPServer returns the underlying http.Server for further tuning.
+func (s *Server) HTTPServer() *http.Server {
+	return s.srv
+}
+
+// SetReady marks the server as ready or not ready to receive traffic.
+func (s *Server) SetReady(ready bool) {
+	var v int32
+	if ready {
+		v = 1
+	}
+	atomic.StoreInt32(&s.ready, v)
+}
+
+// ListenAndServe listens on the address of the Server and serves requests
+// until one of the shutdown signals arrives, then shuts down gracefully.
+// It returns nil after a graceful shutdown.
+func (s *Server) ListenAndServe() error {
+	l, err := net.Listen("tcp", s.srv.Addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve is like ListenAndServe but accepts connections on l.
+func (s *Server) Serve(l net.Listener) error {
+	sig := make(chan os.Signal, 1)
+	if len(s.signals) > 0 {
+		signal.Notify(sig, s.signals...)
+		defer signal.Stop(sig)
+	}
+	errc := make(chan error, 1)
+	go func() { errc <- s.srv.Serve(l) }()
+
+	select {
+	case err := <-errc:
+		if errors.Is(err, http.ErrServerClosed) {
+			return nil
+		}
+		return err
+	case v := <-sig:
+		glog.Infof("http server %s: %v received, shutting down", s.srv.Addr, v)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s.drainTimeout)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		return err
+	}
+	<-errc
+	return nil
+}
+
+// Shutdown marks the server as not ready and waits for in-flight requests
+// to finish until ctx is done.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.SetReady(false)
+	err := s.srv.Shutdown(ctx)
+	if err != nil {
+		glog.Warningf("http server %s: shutdown: %v", s.srv.Addr, err)
+	}
+	return err
+}
+
+func (s *Server) serveReadyz(w http.ResponseWriter, req *http.Request) {
+	if atomic.LoadInt32(&s.ready) == 0 {
+		WriteJSONError(w, http.StatusServiceUnavailable, "shutting down")
+		return
+	}
+	for name, check := range s.readyCheck {
+		if err := check(req.Context()); err != nil {
+			WriteJSONError(w, http.StatusServiceUnavailable,
+				fmt.Sprintf("%s: %v", name, err))
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	io.WriteString(w, "ok\n")
+}
+
+// HealthzHandler answers 200 ok as long as the process serves requests.
+func HealthzHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		io.WriteString(w, "ok\n")
+	})
+}
+
+// ErrorResponse is the JSON body written by WriteJSONError.
+type ErrorResponse struct {
+	Code      int    `json:"code"`
+	Message   string `json:"message"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// WriteJSONError writes an error response with the given status code and
+// message as JSON.
+func WriteJSONError(w http.ResponseWriter, statusCode int, message string) {
+	resp := ErrorResponse{Code: statusCode, Message: message,
+		RequestID: w.Header().Get(RequestIDHeader)}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// RequestIDHandler takes the request ID from the X-Request-Id header or
+// generates a new one, stores it in the request context, see
+// RequestIDFromContext, and echoes it in the response. Outgoing requests
+// of a Client with the RequestIDMiddleware propagate it.
+func RequestIDHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		id := req.Header.Get(RequestIDHeader)
+		if id == "" {
+			id = NewRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+		next.ServeHTTP(w, req.WithContext(WithRequestID(req.Context(), id)))
+	})
+}
+
+// RecoverMiddleware recovers from panics in next, logs them with the
+// stack to glog and answers 500 in JSON if nothing was written yet.
+func RecoverMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		rw, ok := w.(*responseWriter)
+		if !ok {
+			rw = &responseWriter{ResponseWriter: w}
+		}
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if v == http.ErrAbortHandler {
+				panic(v)
+			}
+			glog.Errorf("http server: panic serving %s %s: %v\n%s",
+				req.Method, req.URL, v, debug.Stack())
+			if rw.status == 0 {
+				WriteJSONError(rw, http.StatusInternalServerError,
+					http.StatusText(http.StatusInternalServerError))
+			}
+		}()
+		next.ServeHTTP(rw, req)
+	})
+}
+
+// AccessLogMiddleware logs every request in the given format to w, or to
+// glog if w is nil. The format may contain the variables $remote_addr,
+// $method, $uri, $proto, $host, $status, $bytes, $duration_ms, $duration,
+// $user_agent, $referer, $request_id and $time.
+func AccessLogMiddleware(format string, w io.Writer) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			start := time.Now()
+			lw := &responseWriter{ResponseWriter: rw}
+			defer func() {
+				line := formatAccessLog(format, req, lw, start)
+				if w != nil {
+					io.WriteString(w, line+"\n")
+				} else {
+					glog.Info(line)
+				}
+			}()
+			next.ServeHTTP(lw, req)
+		})
+	}
+}
+
+func formatAccessLog(format string, req *http.Request, rw *responseWriter,
+	start time.Time) string {
+	d := time.Since(start)
+	status := rw.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	dash := func(s string) string {
+		if s == "" {
+			return "-"
+		}
+		return s
+	}
+	r := strings.NewReplacer(
+		"$remote_addr", req.RemoteAddr,
+		"$method", req.Method,
+		"$uri", req.RequestURI,
+		"$proto", req.Proto,
+		"$host", req.Host,
+		"$status", strconv.Itoa(status),
+		"$bytes", strconv.FormatInt(rw.bytes, 10),
+		"$duration_ms", strconv.FormatFloat(d.Seconds()*1000, 'f', 3, 64),
+		"$duration", d.String(),
+		"$user_agent", dash(req.UserAgent()),
+		"$referer", dash(req.Referer()),
+		"$request_id", dash(RequestIDFromContext(req.Context())),
+		"$time", start.Format(time.RFC3339),
+	)
+	return r.Replace(format)
+}
+
+// responseWriter records the status code and the size of a response.
+type responseWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *responseWriter) WriteHeader(statusCode int) {
+	if w.status == 0 {
+		w.status = statusCode
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *responseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Flush implements http.Flusher for streaming handlers.
+func (w *responseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the original writer.
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
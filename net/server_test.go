@@ -0,0 +1,163 @@
+// Copyright 2018 JXB. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package net
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// syncBuffer is a bytes.Buffer safe for concurrent use.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestServerRecoverAndAccessLog(t *testing.T) {
+	var log syncBuffer
+	s, err := NewServer(":0", SetAccessLog("$method $uri $status $bytes "+
+		"$request_id", &log))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.HandleFunc("/panic", func(w http.ResponseWriter, req *http.Request) {
+		panic("boom")
+	})
+	s.HandleFunc("/hello", func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("hello " + RequestIDFromContext(req.Context())))
+	})
+	ts := httptest.NewServer(s.Handler())
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/panic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var e ErrorResponse
+	json.NewDecoder(resp.Body).Decode(&e)
+	resp.Body.Close()
+	if resp.StatusCode != 500 || e.Code != 500 || e.RequestID == "" ||
+		!strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") {
+		t.Fatalf("unexpected panic response %d %+v", resp.StatusCode, e)
+	}
+
+	req, _ := http.NewRequest("GET", ts.URL+"/hello?x=1", nil)
+	req.Header.Set(RequestIDHeader, "abc")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.Header.Get(RequestIDHeader) != "abc" {
+		t.Fatalf("request ID not echoed: %q", resp.Header.Get(RequestIDHeader))
+	}
+	lines := strings.Split(strings.TrimSpace(log.String()), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "GET /panic 500 ") ||
+		lines[1] != "GET /hello?x=1 200 9 abc" {
+		t.Fatalf("unexpected access log %q", lines)
+	}
+}
+
+func TestServerHealth(t *testing.T) {
+	var fail bool
+	s, _ := NewServer(":0", SetAccessLog("", nil),
+		SetReadyCheck("db", func(ctx context.Context) error {
+			if fail {
+				return errors.New("down")
+			}
+			return nil
+		}))
+	ts := httptest.NewServer(s.Handler())
+	defer ts.Close()
+
+	status := func(path string) int {
+		resp, err := http.Get(ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if status("/healthz") != 200 || status("/readyz") != 200 {
+		t.Fatal("server should be healthy and ready")
+	}
+	fail = true
+	if status("/readyz") != 503 {
+		t.Fatal("failing check should make the server not ready")
+	}
+	fail = false
+	s.SetReady(false)
+	if status("/readyz") != 503 || status("/healthz") != 200 {
+		t.Fatal("server should be healthy but not ready")
+	}
+}
+
+func TestServerGracefulShutdown(t *testing.T) {
+	s, _ := NewServer(":0", SetAccessLog("", nil), SetShutdownSignals(),
+		SetDrainTimeout(time.Second))
+	started := make(chan struct{})
+	s.HandleFunc("/slow", func(w http.ResponseWriter, req *http.Request) {
+		close(started)
+		time.Sleep(200 * time.Millisecond)
+		w.Write([]byte("done"))
+	})
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- s.Serve(l) }()
+
+	got := make(chan string, 1)
+	go func() {
+		body, err := Get("http://"+l.Addr().String()+"/slow", 2000)
+		if err != nil {
+			got <- err.Error()
+			return
+		}
+		got <- string(body)
+	}()
+	<-started
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if body := <-got; body != "done" {
+		t.Fatalf("in-flight request not drained: %s", body)
+	}
+	if err := <-served; err != nil {
+		t.Fatalf("want nil after graceful shutdown, got %v", err)
+	}
+}
@@ -0,0 +1,269 @@
+// Copyright 2018 JXB. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package net
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultLatencyBuckets are the upper bounds of the latency histogram
+// buckets.
+var DefaultLatencyBuckets = []time.Duration{
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+// ClientStats contains statistics of the requests sent by a Client.
+// Use the Stats func to return a snapshot.
+type ClientStats struct {
+	Hosts map[string]*HostStats // stats for each upstream host
+}
+
+// HostStats contains the statistics of the requests sent to one host.
+// Every attempt of a retried request counts as a request.
+type HostStats struct {
+	Requests    int64 // # of requests sent
+	Succeeded   int64 // # of requests answered with a success status
+	Failed4xx   int64 // # of requests answered with a 4xx status
+	Failed5xx   int64 // # of requests answered with a 5xx status
+	FailedOther int64 // # of requests answered with another status
+	Errors      int64 // # of requests failed without response
+	Timeouts    int64 // # of Errors caused by a timeout
+	Retries     int64 // # of retries
+
+	Latency *LatencyHistogram // time until the response header arrived
+}
+
+// LatencyHistogram is a cumulative latency histogram: Counts[i] is the
+// number of requests that took at most Buckets[i].
+type LatencyHistogram struct {
+	Buckets []time.Duration // upper bounds of the buckets
+	Counts  []int64         // cumulative count per bucket
+	Count   int64           // total number of observations
+	Sum     time.Duration   // sum of all observations
+}
+
+func newLatencyHistogram() *LatencyHistogram {
+	return &LatencyHistogram{
+		Buckets: DefaultLatencyBuckets,
+		Counts:  make([]int64, len(DefaultLatencyBuckets)),
+	}
+}
+
+func (h *LatencyHistogram) observe(d time.Duration) {
+	for i, b := range h.Buckets {
+		if d <= b {
+			h.Counts[i]++
+		}
+	}
+	h.Count++
+	h.Sum += d
+}
+
+func (h *LatencyHistogram) dup() *LatencyHistogram {
+	dst := new(LatencyHistogram)
+	dst.Buckets = h.Buckets
+	dst.Counts = append([]int64(nil), h.Counts...)
+	dst.Count = h.Count
+	dst.Sum = h.Sum
+	return dst
+}
+
+func (st *HostStats) dup() *HostStats {
+	dst := new(HostStats)
+	*dst = *st
+	dst.Latency = st.Latency.dup()
+	return dst
+}
+
+// statsRecorder collects the statistics of a Client.
+type statsRecorder struct {
+	mu    sync.Mutex
+	hosts map[string]*HostStats
+}
+
+func newStatsRecorder() *statsRecorder {
+	return &statsRecorder{hosts: make(map[string]*HostStats)}
+}
+
+// host returns the stats of host; the caller must hold the lock.
+func (r *statsRecorder) host(host string) *HostStats {
+	st, ok := r.hosts[host]
+	if !ok {
+		st = &HostStats{Latency: newLatencyHistogram()}
+		r.hosts[host] = st
+	}
+	return st
+}
+
+func (r *statsRecorder) recordRequest(host string, statusCode int,
+	success bool, err error, latency time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	st := r.host(host)
+	st.Requests++
+	switch {
+	case err != nil:
+		st.Errors++
+		if isTimeout(err) {
+			st.Timeouts++
+		}
+		return
+	case success:
+		st.Succeeded++
+	case statusCode >= 400 && statusCode < 500:
+		st.Failed4xx++
+	case statusCode >= 500:
+		st.Failed5xx++
+	default:
+		st.FailedOther++
+	}
+	st.Latency.observe(latency)
+}
+
+func (r *statsRecorder) recordRetry(host string) {
+	r.mu.Lock()
+	r.host(host).Retries++
+	r.mu.Unlock()
+}
+
+func (r *statsRecorder) snapshot() *ClientStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stats := &ClientStats{Hosts: make(map[string]*HostStats, len(r.hosts))}
+	for host, st := range r.hosts {
+		stats.Hosts[host] = st.dup()
+	}
+	return stats
+}
+
+// isTimeout reports whether err was caused by a timeout.
+func isTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
+
+// Stats returns a snapshot of the statistics of the default Client.
+func Stats() *ClientStats {
+	return DefaultClient().Stats()
+}
+
+// StatsHandler serves the statistics of the default Client in the
+// Prometheus text format.
+func StatsHandler() http.Handler {
+	return DefaultClient().StatsHandler()
+}
+
+// Stats returns a snapshot of the statistics of the Client.
+func (c *Client) Stats() *ClientStats {
+	return c.stats.snapshot()
+}
+
+// StatsHandler serves the statistics of the Client in the Prometheus text
+// exposition format, e.g. under /metrics.
+func (c *Client) StatsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		w.Write(c.Stats().Prometheus())
+	})
+}
+
+// Prometheus formats the statistics in the Prometheus text exposition
+// format.
+func (st *ClientStats) Prometheus() []byte {
+	hosts := make([]string, 0, len(st.Hosts))
+	for host := range st.Hosts {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+
+	var buf bytes.Buffer
+	counter := func(name, help string, values func(hs *HostStats) [][2]string) {
+		fmt.Fprintf(&buf, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+		for _, host := range hosts {
+			for _, v := range values(st.Hosts[host]) {
+				fmt.Fprintf(&buf, "%s{host=%s%s} %s\n", name, labelValue(host),
+					v[0], v[1])
+			}
+		}
+	}
+	i64 := func(n int64) string { return strconv.FormatInt(n, 10) }
+
+	counter("sailor_http_client_requests_total",
+		"Requests sent by result.", func(hs *HostStats) [][2]string {
+			return [][2]string{
+				{`,result="success"`, i64(hs.Succeeded)},
+				{`,result="4xx"`, i64(hs.Failed4xx)},
+				{`,result="5xx"`, i64(hs.Failed5xx)},
+				{`,result="other"`, i64(hs.FailedOther)},
+				{`,result="error"`, i64(hs.Errors)},
+			}
+		})
+	counter("sailor_http_client_timeouts_total",
+		"Requests failed by a timeout.", func(hs *HostStats) [][2]string {
+			return [][2]string{{"", i64(hs.Timeouts)}}
+		})
+	counter("sailor_http_client_retries_total",
+		"Retries of failed requests.", func(hs *HostStats) [][2]string {
+			return [][2]string{{"", i64(hs.Retries)}}
+		})
+
+	name := "sailor_http_client_request_duration_seconds"
+	fmt.Fprintf(&buf, "# HELP %s Time until the response header arrived.\n"+
+		"# TYPE %s histogram\n", name, name)
+	for _, host := range hosts {
+		h := st.Hosts[host].Latency
+		l := labelValue(host)
+		for i, b := range h.Buckets {
+			fmt.Fprintf(&buf, "%s_bucket{host=%s,le=\"%s\"} %d\n", name, l,
+				strconv.FormatFloat(b.Seconds(), 'g', -1, 64), h.Counts[i])
+		}
+		fmt.Fprintf(&buf, "%s_bucket{host=%s,le=\"+Inf\"} %d\n", name, l, h.Count)
+		fmt.Fprintf(&buf, "%s_sum{host=%s} %s\n", name, l,
+			strconv.FormatFloat(h.Sum.Seconds(), 'g', -1, 64))
+		fmt.Fprintf(&buf, "%s_count{host=%s} %d\n", name, l, h.Count)
+	}
+	return buf.Bytes()
+}
+
+// labelValue quotes s as a Prometheus label value.
+func labelValue(s string) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, `"`, `\"`, -1)
+	s = strings.Replace(s, "\n", `\n`, -1)
+	return `"` + s + `"`
+}
@@ -0,0 +1,101 @@
+// Copyright 2018 JXB. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package net
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestClientStats(t *testing.T) {
+	var calls int32
+	ts := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			switch req.URL.Path {
+			case "/missing":
+				w.WriteHeader(404)
+			case "/flaky":
+				if atomic.AddInt32(&calls, 1) == 1 {
+					w.WriteHeader(503)
+				}
+			case "/slow":
+				time.Sleep(100 * time.Millisecond)
+			}
+		}))
+	defer ts.Close()
+
+	c, err := NewClient(SetRetry(2, time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Get(ts.URL + "/ok")
+	c.Get(ts.URL + "/missing")
+	c.Get(ts.URL + "/flaky")
+	c.Get(ts.URL+"/slow", 20, 0, 0)
+
+	u, _ := url.Parse(ts.URL)
+	st := c.Stats().Hosts[u.Host]
+	if st == nil {
+		t.Fatal("no stats recorded for host")
+	}
+	if st.Requests != 5 || st.Succeeded != 2 || st.Failed4xx != 1 ||
+		st.Failed5xx != 1 || st.Errors != 1 || st.Timeouts != 1 ||
+		st.Retries != 1 {
+		t.Fatalf("unexpected stats: %+v", st)
+	}
+	if st.Latency.Count != 4 {
+		t.Fatalf("want 4 latency observations, got %d", st.Latency.Count)
+	}
+
+	// the snapshot is a copy
+	st.Requests = 0
+	if c.Stats().Hosts[u.Host].Requests != 5 {
+		t.Fatal("Stats should return a copy")
+	}
+}
+
+func TestStatsHandler(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {}))
+	defer ts.Close()
+
+	c, err := NewClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Get(ts.URL); err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	c.StatsHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := ioutil.ReadAll(rec.Body)
+	u, _ := url.Parse(ts.URL)
+	for _, want := range []string{
+		"# TYPE sailor_http_client_requests_total counter",
+		`sailor_http_client_requests_total{host="` + u.Host + `",result="success"} 1`,
+		`sailor_http_client_request_duration_seconds_bucket{host="` + u.Host + `",le="+Inf"} 1`,
+		`sailor_http_client_request_duration_seconds_count{host="` + u.Host + `"} 1`,
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Fatalf("metrics miss %q:\n%s", want, body)
+		}
+	}
+}
@@ -0,0 +1,391 @@
+// Copyright 2018 JXB. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package net
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zlxtqbdgdgd/sailor/util"
+)
+
+// RewindFunc returns a fresh copy of a request body. It is called before
+// every retry of a streaming request, because the body of the previous
+// attempt has already been consumed.
+type RewindFunc func() (io.Reader, error)
+
+// RewindSeeker returns a RewindFunc that seeks rs back to its start, e.g.
+// for an *os.File.
+func RewindSeeker(rs io.ReadSeeker) RewindFunc {
+	return func() (io.Reader, error) {
+		if _, err := rs.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		return rs, nil
+	}
+}
+
+// lazyBody is a request body produced by open on the first Read, so
+// nothing is opened or started for a request that is never sent.
+type lazyBody struct {
+	open RewindFunc
+
+	mu     sync.Mutex
+	r      io.Reader
+	closed bool
+}
+
+func (b *lazyBody) Read(p []byte) (int, error) {
+	b.mu.Lock()
+	if b.r == nil {
+		if b.closed {
+			b.mu.Unlock()
+			return 0, errors.New("read on closed request body")
+		}
+		r, err := b.open()
+		if err != nil {
+			b.mu.Unlock()
+			return 0, err
+		}
+		b.r = r
+	}
+	r := b.r
+	b.mu.Unlock()
+	return r.Read(p)
+}
+
+func (b *lazyBody) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	if c, ok := b.r.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// streamBody closes the response body and releases the request context.
+type streamBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *streamBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+func DoStreamCtx(ctx context.Context, reqType, url string,
+	headers map[string]string, body io.Reader, timeout int) (int,
+	io.ReadCloser, map[string][]string, error) {
+	return DefaultClient().DoStreamCtx(ctx, reqType, url, headers, body,
+		timeout)
+}
+
+func RetryDoStreamCtx(ctx context.Context, reqType, url string,
+	headers map[string]string, body io.Reader, rewind RewindFunc,
+	timeout int, policy RetryPolicy) (io.ReadCloser, error) {
+	return DefaultClient().RetryDoStreamCtx(ctx, reqType, url, headers, body,
+		rewind, timeout, policy)
+}
+
+func Download(ctx context.Context, url, path string) (int64, error) {
+	return DefaultClient().Download(ctx, url, path)
+}
+
+// DoStreamCtx sends a single request with a streamed body and returns the
+// response body unread. The caller must close it. timeout, in
+// milliseconds, bounds the whole exchange including reading the body;
+// 0 leaves it to ctx. The overall timeout of the underlying http.Client
+// does not apply to streams.
+func (c *Client) DoStreamCtx(ctx context.Context, reqType, url string,
+	headers map[string]string, body io.Reader, timeout int) (int,
+	io.ReadCloser, map[string][]string, error) {
+	return c.doStream(ctx, reqType, url, headers, body, nil, timeout)
+}
+
+// RetryDoStreamCtx is like DoStreamCtx but retries as long as the policy
+// allows. rewind provides the body for every retry; if it is nil and body
+// is not, the request is not retried.
+func (c *Client) RetryDoStreamCtx(ctx context.Context, reqType, url string,
+	headers map[string]string, body io.Reader, rewind RewindFunc,
+	timeout int, policy RetryPolicy) (io.ReadCloser, error) {
+	if body != nil && rewind == nil {
+		policy = NoRetry
+	}
+	var rc io.ReadCloser
+	first := true
+	err := c.retryLoop(ctx, url, policy, func() (int, map[string][]string, error) {
+		if !first && rewind != nil {
+			var err error
+			if body, err = rewind(); err != nil {
+				return 0, nil, fmt.Errorf("rewind request body error: %v", err)
+			}
+		}
+		first = false
+		statusCode, r, header, err := c.doStream(ctx, reqType, url, headers,
+			body, rewind, timeout)
+		rc = r
+		return statusCode, header, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rc, nil
+}
+
+func (c *Client) doStream(ctx context.Context, reqType, url string,
+	headers map[string]string, body io.Reader, rewind RewindFunc,
+	timeout int) (int, io.ReadCloser, map[string][]string, error) {
+	req, err := http.NewRequest(reqType, c.resolveURL(url), body)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	if rewind != nil {
+		// lets the transport replay the body on redirects
+		req.GetBody = func() (io.ReadCloser, error) {
+			r, err := rewind()
+			if err != nil {
+				return nil, err
+			}
+			return ioutil.NopCloser(r), nil
+		}
+	}
+	for k, v := range c.header {
+		req.Header.Set(k, v)
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	release, err := c.acquire(ctx, req)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	var (
+		actx   context.Context
+		cancel context.CancelFunc
+	)
+	if timeout > 0 {
+		actx, cancel = context.WithTimeout(ctx,
+			time.Duration(timeout)*time.Millisecond)
+	} else {
+		actx, cancel = context.WithCancel(ctx)
+	}
+	req = req.WithContext(actx)
+	hc := *c.c
+	hc.Timeout = 0
+	resp, err := c.do(ctx, &hc, req, release)
+	if err != nil {
+		cancel()
+		return 0, nil, nil, err
+	}
+	if !c.successStatus[resp.StatusCode] {
+		defer cancel()
+		defer resp.Body.Close()
+		snippet, _ := ioutil.ReadAll(io.LimitReader(resp.Body, MaxErrorBodySize))
+		return resp.StatusCode, nil, resp.Header, newHTTPError(reqType,
+			req.URL.String(), resp.StatusCode, resp.Header, snippet)
+	}
+	return resp.StatusCode, &streamBody{resp.Body, cancel}, resp.Header, nil
+}
+
+// Download streams url into the file at path. The data is first written
+// to path+".part", which is renamed to path once complete, so readers
+// never see a partial file. If a previous Download left a .part file
+// behind, the transfer resumes from its end with a Range request. The ETag
+// or Last-Modified of the resource is kept in path+".part.validator" and
+// sent as If-Range, so a resource that changed meanwhile is downloaded
+// again from the start; without a validator the transfer never resumes.
+// Failed transfers are resumed as long as the retry policy of the client
+// allows. It returns the size of the file.
+func (c *Client) Download(ctx context.Context, url, path string) (int64, error) {
+	part := path + ".part"
+	var size int64
+	err := c.retryLoop(ctx, url, c.retryPolicy, func() (int, map[string][]string, error) {
+		var statusCode int
+		var header map[string][]string
+		var err error
+		size, statusCode, header, err = c.downloadOnce(ctx, url, part)
+		return statusCode, header, err
+	})
+	if err != nil {
+		// keep partial data for a later resume, but no empty leftovers
+		if fi, e := os.Stat(part); e == nil && fi.Size() == 0 {
+			os.Remove(part)
+			os.Remove(part + ".validator")
+		}
+		return 0, err
+	}
+	if err := util.ReplaceFile(part, path); err != nil {
+		return 0, fmt.Errorf("cannot replace %q with %q: %v", part, path, err)
+	}
+	os.Remove(part + ".validator")
+	return size, nil
+}
+
+// downloadOnce appends the missing part of url to the file part.
+func (c *Client) downloadOnce(ctx context.Context, url, part string) (int64,
+	int, map[string][]string, error) {
+	f, err := os.OpenFile(part, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	defer f.Close()
+	offset, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+
+	var headers map[string]string
+	if offset > 0 {
+		validator, _ := ioutil.ReadFile(part + ".validator")
+		if len(validator) == 0 {
+			// no way to tell whether the data still matches, start over
+			if err := f.Truncate(0); err != nil {
+				return 0, 0, nil, err
+			}
+			if offset, err = f.Seek(0, io.SeekStart); err != nil {
+				return 0, 0, nil, err
+			}
+		} else {
+			headers = map[string]string{
+				"Range":    fmt.Sprintf("bytes=%d-", offset),
+				"If-Range": string(validator),
+			}
+		}
+	}
+	req, err := http.NewRequest("GET", c.resolveURL(url), nil)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	for k, v := range c.header {
+		req.Header.Set(k, v)
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	release, err := c.acquire(ctx, req)
+	if err != nil {
+		return offset, 0, nil, err
+	}
+	req = req.WithContext(ctx)
+	hc := *c.c
+	hc.Timeout = 0
+	resp, err := c.do(ctx, &hc, req, release)
+	if err != nil {
+		return offset, 0, nil, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusPartialContent:
+		if first, _ := parseContentRange(resp.Header.Get("Content-Range")); first != offset {
+			// the server ignored our offset, start over
+			if err := f.Truncate(0); err != nil {
+				return 0, 0, nil, err
+			}
+			return 0, 0, nil, errors.New("unexpected content range: " +
+				resp.Header.Get("Content-Range"))
+		}
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable:
+		if _, total := parseContentRange(resp.Header.Get("Content-Range")); total == offset {
+			return offset, resp.StatusCode, resp.Header, nil
+		}
+		// the remote file changed, start over
+		if err := f.Truncate(0); err != nil {
+			return 0, 0, nil, err
+		}
+		return 0, 0, nil, errors.New("range not satisfiable: " +
+			resp.Header.Get("Content-Range"))
+	case c.successStatus[resp.StatusCode]:
+		// full content: the resource changed or the server does not
+		// support ranges
+		if offset > 0 {
+			if err := f.Truncate(0); err != nil {
+				return 0, 0, nil, err
+			}
+			if offset, err = f.Seek(0, io.SeekStart); err != nil {
+				return 0, 0, nil, err
+			}
+		}
+	default:
+		snippet, _ := ioutil.ReadAll(io.LimitReader(resp.Body, MaxErrorBodySize))
+		return offset, resp.StatusCode, resp.Header, newHTTPError("GET",
+			req.URL.String(), resp.StatusCode, resp.Header, snippet)
+	}
+	if offset == 0 {
+		if err := saveValidator(part+".validator", resp.Header); err != nil {
+			return 0, 0, nil, err
+		}
+	}
+
+	n, err := io.Copy(f, resp.Body)
+	size := offset + n
+	if err != nil {
+		return size, 0, nil, err
+	}
+	if err := f.Sync(); err != nil {
+		return size, 0, nil, err
+	}
+	return size, resp.StatusCode, resp.Header, nil
+}
+
+// saveValidator stores the strong ETag or else the Last-Modified date of
+// header in file, which is usable as If-Range on resume. Without either
+// the file is removed.
+func saveValidator(file string, header http.Header) error {
+	validator := header.Get("ETag")
+	if validator == "" || strings.HasPrefix(validator, "W/") {
+		validator = header.Get("Last-Modified")
+	}
+	if validator == "" {
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	return ioutil.WriteFile(file, []byte(validator), 0644)
+}
+
+// parseContentRange parses "bytes first-last/total" and "bytes */total".
+// Unknown values are returned as -1.
+func parseContentRange(s string) (first, total int64) {
+	first, total = -1, -1
+	s = strings.TrimPrefix(strings.TrimSpace(s), "bytes ")
+	slash := strings.IndexByte(s, '/')
+	if slash < 0 {
+		return
+	}
+	if t, err := strconv.ParseInt(s[slash+1:], 10, 64); err == nil {
+		total = t
+	}
+	if dash := strings.IndexByte(s[:slash], '-'); dash > 0 {
+		if f, err := strconv.ParseInt(s[:dash], 10, 64); err == nil {
+			first = f
+		}
+	}
+	return
+}
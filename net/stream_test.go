@@ -0,0 +1,214 @@
+// Copyright 2018 JXB. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package net
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetryDoStreamCtx(t *testing.T) {
+	var calls int32
+	ts := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			raw, _ := ioutil.ReadAll(req.Body)
+			if atomic.AddInt32(&calls, 1) == 1 {
+				w.WriteHeader(503)
+				return
+			}
+			w.Write(bytes.ToUpper(raw))
+		}))
+	defer ts.Close()
+
+	payload := strings.NewReader("streamed body")
+	rc, err := RetryDoStreamCtx(context.Background(), "POST", ts.URL, nil,
+		payload, RewindSeeker(payload), 1000,
+		NewRetryPolicy(2, NewConstantBackoff(10*time.Millisecond)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	raw, err := ioutil.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(raw) != "STREAMED BODY" {
+		t.Fatalf("body should be replayed on retry, got %q", raw)
+	}
+
+	// without a rewind function a consumed body is not retried
+	atomic.StoreInt32(&calls, 0)
+	_, err = RetryDoStreamCtx(context.Background(), "POST", ts.URL, nil,
+		strings.NewReader("once"), nil, 1000,
+		NewRetryPolicy(2, NewConstantBackoff(10*time.Millisecond)))
+	if !IsStatus(err, 503) || atomic.LoadInt32(&calls) != 1 {
+		t.Fatalf("want a single 503 attempt, got %v after %d", err, calls)
+	}
+}
+
+func TestDoStreamCtxIgnoresClientTimeout(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(slowHandler))
+	defer ts.Close()
+
+	c, err := NewClient(SetClientTimeout(100 * time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, rc, _, err := c.DoStreamCtx(context.Background(), "GET", ts.URL, nil,
+		nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	raw, err := ioutil.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(raw) != "START\nWORKING\nDONE\n" {
+		t.Fatalf("unexpected body: %q", raw)
+	}
+}
+
+func TestDownloadResume(t *testing.T) {
+	content := strings.Repeat("0123456789", 1000)
+	var ranges []string
+	ts := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			ranges = append(ranges, req.Header.Get("Range"))
+			w.Header().Set("ETag", `"v1"`)
+			http.ServeContent(w, req, "data", time.Time{},
+				strings.NewReader(content))
+		}))
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "download")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "data")
+	// a previous transfer was interrupted after 1234 bytes
+	if err := ioutil.WriteFile(path+".part", []byte(content[:1234]), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path+".part.validator", []byte(`"v1"`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := Download(context.Background(), ts.URL, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(len(content)) {
+		t.Fatalf("want size %d, got %d", len(content), n)
+	}
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(raw) != content {
+		t.Fatal("downloaded content differs")
+	}
+	if len(ranges) != 1 || ranges[0] != "bytes=1234-" {
+		t.Fatalf("want a single resumed request, got %v", ranges)
+	}
+	if _, err := os.Stat(path + ".part"); !os.IsNotExist(err) {
+		t.Fatal("part file should be gone")
+	}
+	if _, err := os.Stat(path + ".part.validator"); !os.IsNotExist(err) {
+		t.Fatal("validator file should be gone")
+	}
+}
+
+func TestDownloadResumeChangedResource(t *testing.T) {
+	content := strings.Repeat("abcdefghij", 1000)
+	var ranges []string
+	ts := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			ranges = append(ranges, req.Header.Get("Range"))
+			w.Header().Set("ETag", `"v2"`)
+			http.ServeContent(w, req, "data", time.Time{},
+				strings.NewReader(content))
+		}))
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "download")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "data")
+	old := strings.Repeat("0123456789", 1000)
+
+	for _, validator := range []string{`"v1"`, ""} {
+		ranges = nil
+		if err := ioutil.WriteFile(path+".part", []byte(old[:1234]), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path+".part.validator", []byte(validator), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Download(context.Background(), ts.URL, path); err != nil {
+			t.Fatal(err)
+		}
+		raw, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(raw) != content {
+			t.Fatalf("validator %q: old and new content spliced", validator)
+		}
+		if validator == "" && (len(ranges) != 1 || ranges[0] != "") {
+			t.Fatalf("no resume without validator, got ranges %v", ranges)
+		}
+	}
+}
+
+func TestDownloadFailureKeepsTarget(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			w.WriteHeader(404)
+		}))
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "download")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "data")
+	if err := ioutil.WriteFile(path, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Download(context.Background(), ts.URL, path); !IsNotFound(err) {
+		t.Fatalf("want 404, got %v", err)
+	}
+	raw, _ := ioutil.ReadFile(path)
+	if string(raw) != "old" {
+		t.Fatalf("target should be untouched, got %q", raw)
+	}
+	if _, err := os.Stat(path + ".part"); !os.IsNotExist(err) {
+		t.Fatal("empty part file should be removed")
+	}
+}